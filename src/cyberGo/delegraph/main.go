@@ -0,0 +1,46 @@
+// delegraph renders a delegation snapshot produced by 'export delegations json' as Graphviz DOT or JSON.
+//
+// Usage: delegraph [-format dot|json] [-principal p] [-var x] [snapshot.json]
+// The snapshot is read from stdin if no file is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"cyberGo/export"
+)
+
+func main() {
+	format := flag.String("format", "dot", "output format: dot or json")
+	principal := flag.String("principal", "", "only delegations from or to this principal")
+	varname := flag.String("var", "", "only delegations on this variable")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	} else if flag.NArg() == 1 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			log.Fatalln("Open snapshot:", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	edges, err := export.Read(in)
+	if err != nil {
+		log.Fatalln("Read snapshot:", err)
+	}
+	out, err := export.Render(*format, export.Filter(edges, *principal, *varname))
+	if err != nil {
+		log.Fatalln("Render:", err)
+	}
+	fmt.Println(strings.TrimSuffix(out, "\n"))
+}
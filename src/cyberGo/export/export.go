@@ -0,0 +1,84 @@
+// Package export renders delegation assertions of the store as Graphviz DOT or JSON.
+// It is shared by the server 'export delegations' command and the offline delegraph tool.
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"cyberGo/store"
+)
+
+var ErrUnknownFormat = errors.New("export: unknown format")
+
+// Filter returns edges with principal as owner or target and on variable varname.
+// Empty principal or varname matches all.
+func Filter(edges []store.DelegationEdge, principal string, varname string) []store.DelegationEdge {
+	res := make([]store.DelegationEdge, 0, len(edges))
+	for _, e := range edges {
+		if principal != "" && e.Owner != principal && e.Target != principal {
+			continue
+		}
+		if varname != "" && e.Var != varname {
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// Render edges in format "dot" or "json"
+func Render(format string, edges []store.DelegationEdge) (string, error) {
+	switch format {
+	case "dot":
+		return DOT(edges), nil
+	case "json":
+		return JSON(edges)
+	}
+	return "", ErrUnknownFormat
+}
+
+// DOT renders edges as Graphviz digraph, edges go from owner to target labeled by right, variable
+// or namespace and expiration time
+func DOT(edges []store.DelegationEdge) string {
+	var buf bytes.Buffer
+	buf.WriteString("digraph delegations {\n")
+	for _, e := range edges {
+		label := e.Right + " " + e.Var
+		if e.Namespace {
+			label = e.Right + " namespace " + e.Var
+		}
+		if e.Expires != "" {
+			label += " until " + e.Expires
+		}
+		fmt.Fprintf(&buf, "\t%s -> %s [label=%s];\n", strconv.Quote(e.Owner), strconv.Quote(e.Target),
+			strconv.Quote(label))
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// JSON renders edges as JSON array, the format read by Read
+func JSON(edges []store.DelegationEdge) (string, error) {
+	if edges == nil {
+		edges = []store.DelegationEdge{}
+	}
+	b, err := json.Marshal(edges)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Read edges from JSON snapshot produced by JSON
+func Read(r io.Reader) ([]store.DelegationEdge, error) {
+	var edges []store.DelegationEdge
+	if err := json.NewDecoder(r).Decode(&edges); err != nil {
+		return nil, err
+	}
+	return edges, nil
+}
@@ -0,0 +1,61 @@
+package export
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cyberGo/store"
+)
+
+var testEdges = []store.DelegationEdge{
+	{Var: "x", Owner: "admin", Target: "alice", Right: "read"},
+	{Var: "x", Owner: "alice", Target: "bob", Right: "read"},
+	{Var: "y", Owner: "admin", Target: "bob", Right: "write"},
+	{Var: "team::", Owner: "admin", Target: "bob", Right: "create", Namespace: true},
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal string
+		varname   string
+		expected  []store.DelegationEdge
+	}{
+		{"no filter", "", "", testEdges},
+		{"principal", "bob", "", testEdges[1:]},
+		{"namespace", "", "team::", testEdges[3:]},
+		{"variable", "", "x", testEdges[:2]},
+		{"principal and variable", "alice", "y", []store.DelegationEdge{}},
+	}
+	for _, tt := range tests {
+		res := Filter(testEdges, tt.principal, tt.varname)
+		if !reflect.DeepEqual(res, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, res)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	dot, err := Render("dot", testEdges[:1])
+	expected := "digraph delegations {\n\t\"admin\" -> \"alice\" [label=\"read x\"];\n}\n"
+	if err != nil || dot != expected {
+		t.Errorf("DOT expected %q, got %q %v", expected, dot, err)
+	}
+	dot, _ = Render("dot", testEdges[3:])
+	expected = "digraph delegations {\n\t\"admin\" -> \"bob\" [label=\"create namespace team::\"];\n}\n"
+	if dot != expected {
+		t.Errorf("DOT expected %q, got %q", expected, dot)
+	}
+	js, err := Render("json", testEdges)
+	if err != nil {
+		t.Fatalf("JSON render failed: %v", err)
+	}
+	edges, err := Read(strings.NewReader(js))
+	if err != nil || !reflect.DeepEqual(edges, testEdges) {
+		t.Errorf("JSON round trip expected %v, got %v %v", testEdges, edges, err)
+	}
+	if _, err = Render("svg", testEdges); err != ErrUnknownFormat {
+		t.Errorf("Unknown format should fail: %v", err)
+	}
+}
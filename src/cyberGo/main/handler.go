@@ -2,15 +2,19 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"log"
+	"math"
 	"net"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
+	"cyberGo/export"
 	"cyberGo/parser"
 	"cyberGo/store"
 )
@@ -38,6 +42,9 @@ const readTimeoutSeconds = 30
 type scope map[string]interface{}
 type function func(args parser.ArgsType) (interface{}, error)
 
+// function on the store state, gets names of the arguments not their values
+type storeFunction func(ls *store.LocalStore, args []string) (interface{}, error)
+
 type Handler struct {
 	conn   net.Conn
 	enc    *json.Encoder
@@ -138,12 +145,56 @@ ParseLoop:
 			result = h.cmdReturn(&cmd)
 		case parser.CmdCreatePrincipal:
 			result = h.cmdCreatePrincipal(&cmd)
+		case parser.CmdExport:
+			result = h.cmdExport(&cmd)
+		case parser.CmdSetDenial:
+			result = h.cmdSetDenial(&cmd)
+		case parser.CmdDeleteDenial:
+			result = h.cmdDeleteDenial(&cmd)
+		case parser.CmdSetQuota:
+			result = h.cmdSetQuota(&cmd)
+		case parser.CmdGrantCapability:
+			result = h.cmdGrantCapability(&cmd)
+		case parser.CmdRevokeCapability:
+			result = h.cmdRevokeCapability(&cmd)
+		case parser.CmdCreateNamespace:
+			result = h.cmdCreateNamespace(&cmd)
+		case parser.CmdCreateGroup:
+			result = h.cmdCreateGroup(&cmd)
+		case parser.CmdAddToGroup:
+			result = h.cmdAddToGroup(&cmd)
+		case parser.CmdRemoveFromGroup:
+			result = h.cmdRemoveFromGroup(&cmd)
+		case parser.CmdRenameVar:
+			result = h.cmdRenameVar(&cmd)
+		case parser.CmdCopyVar, parser.CmdCopyDelegations:
+			result = h.cmdCopyVar(&cmd)
+		case parser.CmdDeletePrincipal:
+			result = h.cmdDeletePrincipal(&cmd)
+		case parser.CmdDisablePrincipal:
+			result = h.cmdDisablePrincipal(&cmd)
+		case parser.CmdEnablePrincipal:
+			result = h.cmdEnablePrincipal(&cmd)
 		case parser.CmdChangePassword:
 			result = h.cmdChangePassword(&cmd)
 		case parser.CmdSet:
 			result = h.cmdSet(&cmd)
 		case parser.CmdAppendTo:
 			result = h.cmdAppendTo(&cmd)
+		case parser.CmdSetField:
+			result = h.cmdSetField(&cmd)
+		case parser.CmdAppendToField:
+			result = h.cmdAppendToField(&cmd)
+		case parser.CmdSetIndex:
+			result = h.cmdSetIndex(&cmd)
+		case parser.CmdRemoveFrom:
+			result = h.cmdRemoveFrom(&cmd)
+		case parser.CmdPop:
+			result = h.cmdPop(&cmd)
+		case parser.CmdPrependTo:
+			result = h.cmdPrependTo(&cmd)
+		case parser.CmdDeleteVar:
+			result = h.cmdDeleteVar(&cmd)
 		case parser.CmdLocal:
 			result = h.cmdLocal(&cmd)
 		case parser.CmdForeach:
@@ -156,6 +207,8 @@ ParseLoop:
 			result = h.cmdDeleteDelegation(&cmd)
 		case parser.CmdDefaultDelegator:
 			result = h.cmdDefaultDelegator(&cmd)
+		case parser.CmdDefaultCreation:
+			result = h.cmdDefaultCreation(&cmd)
 		case parser.CmdTerminate:
 			h.ls.Commit()
 			h.sendSuccessResults(results)
@@ -204,6 +257,19 @@ func (h *Handler) cmdReturn(c *parser.Cmd) interface{} {
 	return &ReturningStatus{"RETURNING", output}
 }
 
+func (h *Handler) cmdExport(c *parser.Cmd) interface{} {
+	edges, err := h.ls.Delegations()
+	if err != nil {
+		return convertError(err)
+	}
+	output, err := export.Render(asString(c.Args[0]),
+		export.Filter(edges, asString(c.Args[1]), asString(c.Args[2])))
+	if err != nil {
+		return statusFailed
+	}
+	return &ReturningStatus{"EXPORT", output}
+}
+
 func (h *Handler) cmdCreatePrincipal(c *parser.Cmd) *Status {
 	if err := h.ls.CreatePrincipal(asString(c.Args[0]), asString(c.Args[1])); err != nil {
 		return convertError(err)
@@ -211,6 +277,89 @@ func (h *Handler) cmdCreatePrincipal(c *parser.Cmd) *Status {
 	return &Status{"CREATE_PRINCIPAL"}
 }
 
+func (h *Handler) cmdSetQuota(c *parser.Cmd) *Status {
+	kind, ok := QuotasMap[asString(c.Args[1])]
+	if !ok {
+		return statusFailed
+	}
+	n, _ := c.Args[2].(parser.Number)
+	if err := h.ls.SetQuota(asString(c.Args[0]), kind, int64(n)); err != nil {
+		return convertError(err)
+	}
+	return &Status{"SET_QUOTA"}
+}
+
+func (h *Handler) cmdGrantCapability(c *parser.Cmd) *Status {
+	capability, ok := CapabilitiesMap[asString(c.Args[0])]
+	if !ok {
+		return statusFailed
+	}
+	if err := h.ls.GrantCapability(capability, asString(c.Args[1])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"GRANT_CAPABILITY"}
+}
+
+func (h *Handler) cmdRevokeCapability(c *parser.Cmd) *Status {
+	capability, ok := CapabilitiesMap[asString(c.Args[0])]
+	if !ok {
+		return statusFailed
+	}
+	if err := h.ls.RevokeCapability(capability, asString(c.Args[1])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"REVOKE_CAPABILITY"}
+}
+
+func (h *Handler) cmdCreateNamespace(c *parser.Cmd) *Status {
+	if err := h.ls.CreateNamespace(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"CREATE_NAMESPACE"}
+}
+
+func (h *Handler) cmdCreateGroup(c *parser.Cmd) *Status {
+	if err := h.ls.CreateGroup(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"CREATE_GROUP"}
+}
+
+func (h *Handler) cmdAddToGroup(c *parser.Cmd) *Status {
+	if err := h.ls.AddToGroup(asString(c.Args[0]), asString(c.Args[1])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"ADD_TO_GROUP"}
+}
+
+func (h *Handler) cmdRemoveFromGroup(c *parser.Cmd) *Status {
+	if err := h.ls.RemoveFromGroup(asString(c.Args[0]), asString(c.Args[1])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"REMOVE_FROM_GROUP"}
+}
+
+func (h *Handler) cmdDeletePrincipal(c *parser.Cmd) *Status {
+	if err := h.ls.DeletePrincipal(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"DELETE_PRINCIPAL"}
+}
+
+func (h *Handler) cmdDisablePrincipal(c *parser.Cmd) *Status {
+	if err := h.ls.DisablePrincipal(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"DISABLE_PRINCIPAL"}
+}
+
+func (h *Handler) cmdEnablePrincipal(c *parser.Cmd) *Status {
+	if err := h.ls.EnablePrincipal(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"ENABLE_PRINCIPAL"}
+}
+
 func (h *Handler) cmdChangePassword(c *parser.Cmd) *Status {
 	if err := h.ls.ChangePassword(asString(c.Args[0]), asString(c.Args[1])); err != nil {
 		return convertError(err)
@@ -241,6 +390,113 @@ func (h *Handler) cmdAppendTo(c *parser.Cmd) *Status {
 	return &Status{"APPEND"}
 }
 
+// set x.f = value
+func (h *Handler) cmdSetField(c *parser.Cmd) *Status {
+	val, err := h.prepareValue(c.Args[2], nil)
+	if err != nil {
+		return convertError(err)
+	}
+	s, ok := val.(string) // record fields may only contain strings
+	if !ok {
+		return statusFailed
+	}
+	if err := h.ls.SetField(asString(c.Args[0]), asString(c.Args[1]), s); err != nil {
+		return convertError(err)
+	}
+	return &Status{"SET"}
+}
+
+// set x[i] = value
+func (h *Handler) cmdSetIndex(c *parser.Cmd) *Status {
+	val, err := h.prepareValue(c.Args[2], nil)
+	if err != nil {
+		return convertError(err)
+	}
+	i, _ := c.Args[1].(parser.Number)
+	if err := h.ls.SetIndex(asString(c.Args[0]), int64(i), val); err != nil {
+		return convertError(err)
+	}
+	return &Status{"SET"}
+}
+
+// append to x.f with value
+func (h *Handler) cmdAppendToField(c *parser.Cmd) *Status {
+	val, err := h.prepareValue(c.Args[2], nil)
+	if err != nil {
+		return convertError(err)
+	}
+	s, ok := val.(string) // record fields may only contain strings
+	if !ok {
+		return statusFailed
+	}
+	if err := h.ls.AppendToField(asString(c.Args[0]), asString(c.Args[1]), s); err != nil {
+		return convertError(err)
+	}
+	return &Status{"APPEND"}
+}
+
+// remove from x where y => condition
+func (h *Handler) cmdRemoveFrom(c *parser.Cmd) *Status {
+	y := asString(c.Args[1])
+	if h.ls.IsVarExist(y) {
+		return statusFailed
+	}
+	expr := c.Args[2]
+	err := h.ls.RemoveFrom(asString(c.Args[0]), func(v interface{}) (bool, error) {
+		val, err := h.prepareValue(expr, scope{y: v})
+		if err != nil {
+			return false, err
+		}
+		s, ok := val.(string)
+		return ok && s == "", nil
+	})
+	if err != nil {
+		return convertError(err)
+	}
+	return &Status{"REMOVE"}
+}
+
+func (h *Handler) cmdPop(c *parser.Cmd) *Status {
+	if err := h.ls.Pop(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"POP"}
+}
+
+// prepend to x with value
+func (h *Handler) cmdPrependTo(c *parser.Cmd) *Status {
+	value, err := h.prepareValue(c.Args[1], nil)
+	if err != nil {
+		return convertError(err)
+	}
+	if err := h.ls.PrependTo(asString(c.Args[0]), value); err != nil {
+		return convertError(err)
+	}
+	return &Status{"PREPEND"}
+}
+
+func (h *Handler) cmdDeleteVar(c *parser.Cmd) *Status {
+	if err := h.ls.DeleteVar(asString(c.Args[0])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"DELETE"}
+}
+
+func (h *Handler) cmdRenameVar(c *parser.Cmd) *Status {
+	if err := h.ls.RenameVar(asString(c.Args[0]), asString(c.Args[1])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"RENAME"}
+}
+
+func (h *Handler) cmdCopyVar(c *parser.Cmd) *Status {
+	err := h.ls.CopyVar(asString(c.Args[0]), asString(c.Args[1]), c.Type == parser.CmdCopyDelegations)
+	if err != nil {
+		return convertError(err)
+	}
+	return &Status{"COPY"}
+}
+
 func (h *Handler) cmdLocal(c *parser.Cmd) *Status {
 	val, err := h.prepareValue(c.Args[1], nil)
 	if err != nil {
@@ -275,7 +531,7 @@ func (h *Handler) cmdForeach(c *parser.Cmd) *Status {
 		}
 		newx[i] = val
 	}
-	if err := h.ls.Set(varname, newx); err != nil {
+	if err := h.ls.Set(foreachTarget(c), newx); err != nil {
 		return convertError(err)
 	}
 	return &Status{"FOREACH"}
@@ -307,28 +563,83 @@ func (h *Handler) cmdFiltereach(c *parser.Cmd) *Status {
 			res = append(res, v)
 		}
 	}
-	if err := h.ls.Set(varname, res); err != nil {
+	if err := h.ls.Set(foreachTarget(c), res); err != nil {
 		return convertError(err)
 	}
 	return &Status{"FILTEREACH"}
 }
 
+// returns variable name where the result of foreach and filtereach should be saved:
+// z for 'into z' form or the source variable otherwise
+func foreachTarget(c *parser.Cmd) string {
+	if len(c.Args) > 3 {
+		return asString(c.Args[3])
+	}
+	return asString(c.Args[1])
+}
+
 func (h *Handler) cmdSetDelegation(c *parser.Cmd) *Status {
-	if err := h.ls.SetDelegation(asString(c.Args[0]), asString(c.Args[1]),
-		toPermission(asString(c.Args[2])), asString(c.Args[3])); err != nil {
+	var expires time.Time
+	if len(c.Args) > 4 { // for "d" or until "t"
+		expiry := c.Args[4].(parser.Expiry)
+		if expiry.For != 0 {
+			expires = h.ls.Now().Add(expiry.For)
+		} else {
+			expires = expiry.Until
+		}
+	}
+	var err error
+	if pattern, ok := c.Args[0].(string); ok { // set delegation "pattern" q <right> -> p
+		err = h.ls.SetPatternDelegation(pattern, asString(c.Args[1]), toPermission(asString(c.Args[2])),
+			asString(c.Args[3]), expires)
+	} else {
+		err = h.ls.SetDelegationExpiring(asString(c.Args[0]), asString(c.Args[1]),
+			toPermission(asString(c.Args[2])), asString(c.Args[3]), expires)
+	}
+	if err != nil {
 		return convertError(err)
 	}
 	return &Status{"SET_DELEGATION"}
 }
 
-func (h *Handler) cmdDeleteDelegation(c *parser.Cmd) *Status {
-	if err := h.ls.DeleteDelegation(asString(c.Args[0]), asString(c.Args[1]),
+func (h *Handler) cmdSetDenial(c *parser.Cmd) *Status {
+	if err := h.ls.SetDenial(asString(c.Args[0]), asString(c.Args[1]),
+		toPermission(asString(c.Args[2])), asString(c.Args[3])); err != nil {
+		return convertError(err)
+	}
+	return &Status{"SET_DENIAL"}
+}
+
+func (h *Handler) cmdDeleteDenial(c *parser.Cmd) *Status {
+	if err := h.ls.DeleteDenial(asString(c.Args[0]), asString(c.Args[1]),
 		toPermission(asString(c.Args[2])), asString(c.Args[3])); err != nil {
 		return convertError(err)
 	}
+	return &Status{"DELETE_DENIAL"}
+}
+
+func (h *Handler) cmdDeleteDelegation(c *parser.Cmd) *Status {
+	var err error
+	if pattern, ok := c.Args[0].(string); ok { // delete delegation "pattern" q <right> -> p
+		err = h.ls.DeletePatternDelegation(pattern, asString(c.Args[1]), toPermission(asString(c.Args[2])),
+			asString(c.Args[3]))
+	} else {
+		err = h.ls.DeleteDelegation(asString(c.Args[0]), asString(c.Args[1]),
+			toPermission(asString(c.Args[2])), asString(c.Args[3]))
+	}
+	if err != nil {
+		return convertError(err)
+	}
 	return &Status{"DELETE_DELEGATION"}
 }
 
+func (h *Handler) cmdDefaultCreation(c *parser.Cmd) *Status {
+	if err := h.ls.SetRestrictedCreation(asString(c.Args[0]) == "restricted"); err != nil {
+		return convertError(err)
+	}
+	return &Status{"DEFAULT_CREATION"}
+}
+
 func (h *Handler) cmdDefaultDelegator(c *parser.Cmd) *Status {
 	if err := h.ls.SetDefaultDelegator(asString(c.Args[0])); err != nil {
 		return convertError(err)
@@ -351,6 +662,8 @@ func (h *Handler) prepareValue(in interface{}, sc scope) (interface{}, error) {
 			return nil, err
 		}
 		return val, nil
+	case parser.Number:
+		return store.NumberVal(x), nil
 	case parser.List:
 		return store.ListVal(x), nil
 	case parser.FieldVal:
@@ -372,6 +685,33 @@ func (h *Handler) prepareValue(in interface{}, sc scope) (interface{}, error) {
 				return res, nil
 			}
 		}
+	case parser.Index:
+		lst, err := h.prepareList(x.Var, sc)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := listPosition(int64(x.Index), len(lst))
+		if !ok || i == len(lst) {
+			return nil, errPrepareFailed
+		}
+		return lst[i], nil
+	case parser.Slice:
+		lst, err := h.prepareList(x.Var, sc)
+		if err != nil {
+			return nil, err
+		}
+		low, high := int64(0), int64(len(lst))
+		if x.Low != nil {
+			low = int64(*x.Low)
+		}
+		if x.High != nil {
+			high = int64(*x.High)
+		}
+		res, ok := sliceList(lst, low, high)
+		if !ok {
+			return nil, errPrepareFailed
+		}
+		return res, nil
 	case parser.Record:
 		rec := make(store.RecordVal, len(x))
 		for k, v := range x {
@@ -387,6 +727,17 @@ func (h *Handler) prepareValue(in interface{}, sc scope) (interface{}, error) {
 		}
 		return rec, nil
 	case parser.Function:
+		if fn, ok := storeFunctionsMap[x.Name]; ok {
+			args := make([]string, len(x.Args))
+			for i, v := range x.Args {
+				name, ok := v.(parser.Identifier)
+				if !ok {
+					return nil, errPrepareFailed
+				}
+				args[i] = string(name)
+			}
+			return fn(h.ls, args)
+		}
 		if fn, ok := functionsMap[x.Name]; ok {
 			args := make(parser.ArgsType, len(x.Args))
 			for i, v := range x.Args {
@@ -421,15 +772,112 @@ func (h *Handler) prepareValue(in interface{}, sc scope) (interface{}, error) {
 			return nil, err
 		}
 		return res, nil
+	case parser.Map:
+		lst, sc, err := h.prepareIteration(x.Var, x.List, sc)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(sc, x.Var) // delete scope variable
+		res := make(store.ListVal, len(lst))
+		for i, v := range lst {
+			sc[x.Var] = v
+			val, err := h.prepareValue(x.Expr, sc)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = val
+		}
+		return res, nil
+	case parser.Filter:
+		lst, sc, err := h.prepareIteration(x.Var, x.List, sc)
+		if err != nil {
+			return nil, err
+		}
+		defer delete(sc, x.Var) // delete scope variable
+		res := make(store.ListVal, 0, len(lst))
+		for _, v := range lst {
+			sc[x.Var] = v
+			val, err := h.prepareValue(x.Cond, sc)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := val.(string); ok && s == "" {
+				res = append(res, v)
+			}
+		}
+		return res, nil
 	}
 	return nil, errPrepareFailed
 }
 
+// returns flattened list from scope or store variable x
+func (h *Handler) prepareList(x string, sc scope) (store.ListVal, error) {
+	val, err := h.prepareValue(parser.Identifier(x), sc)
+	if err != nil {
+		return nil, err
+	}
+	lst, ok := val.(store.ListVal)
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return lst.Flatten(), nil
+}
+
+// converts list index (negative index is counted from the end) to position in list of length l
+// returns false if position is out of range [0, l]
+func listPosition(i int64, l int) (int, bool) {
+	if i < 0 {
+		i += int64(l)
+	}
+	if i < 0 || i > int64(l) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// returns elements of lst from index low (inclusive) to high (exclusive), see listPosition
+// returns false if an index is out of range or low is after high
+func sliceList(lst store.ListVal, low, high int64) (store.ListVal, bool) {
+	i, ok1 := listPosition(low, len(lst))
+	j, ok2 := listPosition(high, len(lst))
+	if !ok1 || !ok2 || i > j {
+		return nil, false
+	}
+	return lst[i:j], true
+}
+
+// prepares flattened list for map and filter expressions
+// returns the scope where iteration variable y can be set
+func (h *Handler) prepareIteration(y string, in interface{}, sc scope) (store.ListVal, scope, error) {
+	if sc != nil {
+		if _, ok := sc[y]; ok { // scope variable already exists
+			return nil, nil, errPrepareFailed
+		}
+	}
+	if h.ls.IsVarExist(y) {
+		return nil, nil, errPrepareFailed
+	}
+	val, err := h.prepareValue(in, sc)
+	if err != nil {
+		return nil, nil, err
+	}
+	lst, ok := val.(store.ListVal)
+	if !ok {
+		return nil, nil, errPrepareFailed
+	}
+	if sc == nil {
+		sc = make(scope, 1)
+	}
+	return lst.Flatten(), sc, nil
+}
+
 func convertError(err error) *Status {
 	if err == store.ErrFailed {
 		return statusFailed
 	} else if err == store.ErrDenied {
 		return statusDenied
+	} else if err == store.ErrQuota {
+		return statusFailed
 	} else if err != nil {
 		log.Println("Unknown error:", err)
 		return statusFailed
@@ -438,11 +886,46 @@ func convertError(err error) *Status {
 }
 
 var functionsMap = map[string]function{
-	"split":    splitFunc,
-	"concat":   concatFunc,
-	"tolower":  tolowerFunc,
-	"equal":    equalFunc,
-	"notequal": notequalFunc,
+	"split":      splitFunc,
+	"concat":     concatFunc,
+	"tolower":    tolowerFunc,
+	"equal":      equalFunc,
+	"notequal":   notequalFunc,
+	"add":        addFunc,
+	"sub":        subFunc,
+	"mul":        mulFunc,
+	"div":        divFunc,
+	"mod":        modFunc,
+	"lt":         ltFunc,
+	"gt":         gtFunc,
+	"toupper":    toupperFunc,
+	"length":     lengthFunc,
+	"substring":  substringFunc,
+	"replace":    replaceFunc,
+	"contains":   containsFunc,
+	"startswith": startswithFunc,
+	"endswith":   endswithFunc,
+	"trim":       trimFunc,
+	"indexof":    indexofFunc,
+	"repeat":     repeatFunc,
+	"splitby":    splitbyFunc,
+	"len":        lenFunc,
+	"first":      firstFunc,
+	"last":       lastFunc,
+	"nth":        nthFunc,
+	"slice":      sliceFunc,
+	"reverse":    reverseFunc,
+	"unique":     uniqueFunc,
+	"sort":       sortFunc,
+	"join":       joinFunc,
+	"flatten":    flattenFunc,
+	"keys":       keysFunc,
+	"values":     valuesFunc,
+	"has":        hasFunc,
+	"get":        getFunc,
+	"merge":      mergeFunc,
+	"without":    withoutFunc,
+	"setfield":   setfieldFunc,
 }
 
 var PermissionsMap = map[string]store.Permission{
@@ -450,10 +933,68 @@ var PermissionsMap = map[string]store.Permission{
 	"write":    store.PermissionWrite,
 	"delegate": store.PermissionDelegate,
 	"append":   store.PermissionAppend,
+	"remove":   store.PermissionRemove,
+	"create":   store.PermissionCreate,
+}
+
+var CapabilitiesMap = map[string]store.Capability{
+	"principals": store.CapabilityPrincipals,
+	"passwords":  store.CapabilityPasswords,
+	"groups":     store.CapabilityGroups,
+	"delegator":  store.CapabilityDelegator,
+}
+
+var QuotasMap = map[string]store.QuotaKind{
+	"variables": store.QuotaVariables,
+	"bytes":     store.QuotaBytes,
+	"length":    store.QuotaLength,
+}
+
+var storeFunctionsMap = map[string]storeFunction{
+	"explain":     explainFunc,
+	"permissions": permissionsFunc,
+	"rights":      rightsFunc,
+}
+
+// explain(x, p, right)
+func explainFunc(ls *store.LocalStore, args []string) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	perm, ok := PermissionsMap[args[2]]
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return ls.ExplainPermission(args[0], args[1], perm)
+}
+
+// permissions(x)
+// Returns list of {right, user} records of principals having a right on x, see store Permissions
+func permissionsFunc(ls *store.LocalStore, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	return ls.Permissions(args[0])
+}
+
+// rights(x)
+func rightsFunc(ls *store.LocalStore, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	return ls.Rights(args[0])
 }
 
+// toPermission converts right name, comma separated list of rights or all to store permission
 func toPermission(perm string) store.Permission {
-	return PermissionsMap[perm]
+	if perm == "all" {
+		return store.PermissionAll
+	}
+	var res store.Permission
+	for _, p := range strings.Split(perm, ",") {
+		res.Set(PermissionsMap[p])
+	}
+	return res
 }
 
 func asString(val interface{}) string {
@@ -504,11 +1045,7 @@ func concatFunc(args parser.ArgsType) (interface{}, error) {
 	if !ok1 || !ok2 {
 		return nil, errPrepareFailed
 	}
-	res := s1 + s2
-	if len(res) > 65535 {
-		res = res[:65535]
-	}
-	return res, nil
+	return truncate(s1 + s2), nil
 }
 
 // tolower(s)
@@ -525,16 +1062,223 @@ func tolowerFunc(args parser.ArgsType) (interface{}, error) {
 	return strings.ToLower(s), nil
 }
 
-// equal(<value>,<value>)
-// takes two arguments and returns "" if they are equal, and "0" if they are not.
-// (as with string functions, arguments are evaluated left to right)
-// Arguments are permitted to be strings or records; fails otherwise.
-func equalFunc(args parser.ArgsType) (interface{}, error) {
-	if len(args) != 2 {
+// toupper(s)
+// returns a new string that converts all lowercase characters in s to uppercase.
+// Fails if s is not a string.
+func toupperFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	s, ok := args[0].(string)
+	if !ok {
 		return nil, errPrepareFailed
 	}
+	return strings.ToUpper(s), nil
+}
 
-	// compare strings
+// length(s)
+// returns the number of characters in s.
+// Fails if s is not a string.
+func lengthFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(len(s)), nil
+}
+
+// substring(s,n1,n2)
+// returns the characters of s from position n1 (inclusive) to n2 (exclusive), counting from 0.
+// Positions greater than the length of s are treated as the length of s,
+// so the result is "" if n1 is greater than or equal to n2.
+// Fails if s is not a string, or n1 or n2 is not a non-negative number.
+func substringFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	s, ok := args[0].(string)
+	n1, ok1 := args[1].(store.NumberVal)
+	n2, ok2 := args[2].(store.NumberVal)
+	if !ok || !ok1 || !ok2 || n1 < 0 || n2 < 0 {
+		return nil, errPrepareFailed
+	}
+	l := store.NumberVal(len(s))
+	if n1 > l {
+		n1 = l
+	}
+	if n2 > l {
+		n2 = l
+	}
+	if n1 >= n2 {
+		return "", nil
+	}
+	return s[n1:n2], nil
+}
+
+// replace(s1,s2,s3)
+// returns a new string where all occurrences of s2 in s1 are replaced with s3.
+// The resulting string is truncated to 65535 characters (if it would exceed that length).
+// Fails if s1, s2 or s3 is not a string.
+func replaceFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	s3, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errPrepareFailed
+	}
+	if s2 == "" { // nothing to replace
+		return s1, nil
+	}
+	var res bytes.Buffer
+	for res.Len() <= store.MaxStringLength { // stop as soon as result would be truncated
+		i := strings.Index(s1, s2)
+		if i < 0 {
+			res.WriteString(s1)
+			break
+		}
+		res.WriteString(s1[:i])
+		res.WriteString(s3)
+		s1 = s1[i+len(s2):]
+	}
+	return truncate(res.String()), nil
+}
+
+// contains(s1,s2)
+// returns "" if s1 contains s2, and "0" if it does not.
+// If s1 is a list, returns "" if any element of the flattened s1 is equal to s2.
+// Fails if s2 is not a string, or s1 is neither a string nor a list.
+func containsFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	if lst, ok := listArg(args[0]); ok {
+		return boolResult(indexOf(lst, args[1]) >= 0), nil
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	return boolResult(strings.Contains(s1, s2)), nil
+}
+
+// startswith(s1,s2)
+// returns "" if s1 begins with s2, and "0" if it does not.
+// Fails if s1 or s2 is not a string.
+func startswithFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	return boolResult(strings.HasPrefix(s1, s2)), nil
+}
+
+// endswith(s1,s2)
+// returns "" if s1 ends with s2, and "0" if it does not.
+// Fails if s1 or s2 is not a string.
+func endswithFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	return boolResult(strings.HasSuffix(s1, s2)), nil
+}
+
+// trim(s)
+// returns a new string with all leading and trailing spaces of s removed.
+// Fails if s is not a string.
+func trimFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return strings.Trim(s, " "), nil
+}
+
+// indexof(s1,s2)
+// returns the position of the first occurrence of s2 in s1 (counting from 0), or -1 if s2 is not found.
+// Fails if s1 or s2 is not a string.
+func indexofFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(strings.Index(s1, s2)), nil
+}
+
+// repeat(s,n)
+// returns a new string consisting of n copies of s.
+// The resulting string is truncated to 65535 characters (if it would exceed that length).
+// Fails if s is not a string or n is not a non-negative number.
+func repeatFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	s, ok1 := args[0].(string)
+	n, ok2 := args[1].(store.NumberVal)
+	if !ok1 || !ok2 || n < 0 {
+		return nil, errPrepareFailed
+	}
+	if s == "" {
+		return "", nil
+	}
+	if limit := store.NumberVal(store.MaxStringLength/len(s) + 1); n > limit { // do not allocate more than needed
+		n = limit
+	}
+	return truncate(strings.Repeat(s, int(n))), nil
+}
+
+// splitby(s1,s2)
+// returns a list of the substrings of s1 separated by s2.
+// Fails if s1 or s2 is not a string.
+func splitbyFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	s1, ok1 := args[0].(string)
+	s2, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	parts := strings.Split(s1, s2)
+	res := make(store.ListVal, len(parts))
+	for i, p := range parts {
+		res[i] = p
+	}
+	return res, nil
+}
+
+// equal(<value>,<value>)
+// takes two arguments and returns "" if they are equal, and "0" if they are not.
+// (as with string functions, arguments are evaluated left to right)
+// Arguments are permitted to be strings, numbers or records; fails otherwise.
+func equalFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+
+	// compare strings
 	s1, ok1 := args[0].(string)
 	s2, ok2 := args[1].(string)
 	if ok1 && ok2 {
@@ -545,6 +1289,17 @@ func equalFunc(args parser.ArgsType) (interface{}, error) {
 		}
 	}
 
+	// compare numbers
+	n1, ok1 := args[0].(store.NumberVal)
+	n2, ok2 := args[1].(store.NumberVal)
+	if ok1 && ok2 {
+		if n1 == n2 {
+			return "", nil
+		} else {
+			return "0", nil
+		}
+	}
+
 	// compare records
 	rec1, ok1 := args[0].(store.RecordVal)
 	rec2, ok2 := args[1].(store.RecordVal)
@@ -563,7 +1318,7 @@ func equalFunc(args parser.ArgsType) (interface{}, error) {
 // notequal(<value>,<value>)
 // takes two arguments and returns "" if they are not equal, and "0" if they are.
 // (as with string functions, arguments are evaluated left to right)
-// Arguments are permitted to be strings or records; fails otherwise.
+// Arguments are permitted to be strings, numbers or records; fails otherwise.
 func notequalFunc(args parser.ArgsType) (interface{}, error) {
 	res, err := equalFunc(args)
 	if err != nil {
@@ -575,3 +1330,500 @@ func notequalFunc(args parser.ArgsType) (interface{}, error) {
 		return "", nil
 	}
 }
+
+// list functions
+// All list functions work with the flattened lists (the same way as lists are returned)
+
+// returns flattened list argument
+func listArg(arg interface{}) (store.ListVal, bool) {
+	lst, ok := arg.(store.ListVal)
+	if !ok {
+		return nil, false
+	}
+	return lst.Flatten(), true
+}
+
+// returns position of the first element equal to val or -1 if not found
+func indexOf(lst store.ListVal, val interface{}) int {
+	for i, v := range lst {
+		if reflect.DeepEqual(v, val) {
+			return i
+		}
+	}
+	return -1
+}
+
+// len(l)
+// returns the number of elements in l.
+// Fails if l is not a list.
+func lenFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(len(lst)), nil
+}
+
+// first(l)
+// returns the first element of l.
+// Fails if l is not a list or is empty.
+func firstFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok || len(lst) == 0 {
+		return nil, errPrepareFailed
+	}
+	return lst[0], nil
+}
+
+// last(l)
+// returns the last element of l.
+// Fails if l is not a list or is empty.
+func lastFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok || len(lst) == 0 {
+		return nil, errPrepareFailed
+	}
+	return lst[len(lst)-1], nil
+}
+
+// nth(l,n)
+// returns the element of l at position n, counting from 0.
+// Fails if l is not a list, n is not a number or there is no element at position n.
+func nthFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	lst, ok1 := listArg(args[0])
+	n, ok2 := args[1].(store.NumberVal)
+	if !ok1 || !ok2 || n < 0 || n >= store.NumberVal(len(lst)) {
+		return nil, errPrepareFailed
+	}
+	return lst[n], nil
+}
+
+// slice(l,n1,n2)
+// returns a new list with elements of l from position n1 (inclusive) to n2 (exclusive), counting from 0.
+// Negative positions are counted from the end of l, same as in l[n1:n2].
+// Fails if l is not a list, n1 or n2 is not a number, a position is out of range or n1 is after n2.
+func sliceFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	n1, ok1 := args[1].(store.NumberVal)
+	n2, ok2 := args[2].(store.NumberVal)
+	if !ok || !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	res, ok := sliceList(lst, int64(n1), int64(n2))
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return res, nil
+}
+
+// reverse(l)
+// returns a new list with elements of l in reverse order.
+// Fails if l is not a list.
+func reverseFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	for i, j := 0, len(lst)-1; i < j; i, j = i+1, j-1 {
+		lst[i], lst[j] = lst[j], lst[i]
+	}
+	return lst, nil
+}
+
+// unique(l)
+// returns a new list with elements of l without duplicates (the first occurrence is kept).
+// Fails if l is not a list.
+func uniqueFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	res := make(store.ListVal, 0, len(lst))
+	for _, v := range lst {
+		if indexOf(res, v) < 0 {
+			res = append(res, v)
+		}
+	}
+	return res, nil
+}
+
+// sort(l)
+// returns a new list with elements of l in ascending order.
+// Fails if l is not a list or its elements are not all strings or all numbers.
+func sortFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	if len(lst) == 0 {
+		return lst, nil
+	}
+	switch lst[0].(type) {
+	case string:
+		for _, v := range lst {
+			if _, ok := v.(string); !ok {
+				return nil, errPrepareFailed
+			}
+		}
+		sort.SliceStable(lst, func(i, j int) bool { return lst[i].(string) < lst[j].(string) })
+	case store.NumberVal:
+		for _, v := range lst {
+			if _, ok := v.(store.NumberVal); !ok {
+				return nil, errPrepareFailed
+			}
+		}
+		sort.SliceStable(lst, func(i, j int) bool { return lst[i].(store.NumberVal) < lst[j].(store.NumberVal) })
+	default:
+		return nil, errPrepareFailed
+	}
+	return lst, nil
+}
+
+// join(l,s)
+// returns a new string that is the concatenation of elements of l separated by s.
+// The resulting string is truncated to 65535 characters (if it would exceed that length).
+// Fails if l is not a list of strings or s is not a string.
+func joinFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	lst, ok1 := listArg(args[0])
+	sep, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	var res bytes.Buffer
+	for i, v := range lst {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errPrepareFailed
+		}
+		if res.Len() > store.MaxStringLength { // the rest would be truncated anyway
+			continue
+		}
+		if i > 0 {
+			res.WriteString(sep)
+		}
+		res.WriteString(s)
+	}
+	return truncate(res.String()), nil
+}
+
+// flatten(l)
+// returns a new list with elements of l where all nested lists are replaced with their elements.
+// Fails if l is not a list.
+func flattenFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	lst, ok := listArg(args[0])
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	return lst, nil
+}
+
+// record functions
+
+// returns record keys in ascending order
+func sortedKeys(rec store.RecordVal) []string {
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// returns a copy of record with enough capacity for extra fields
+func copyRecord(rec store.RecordVal, extra int) store.RecordVal {
+	res := make(store.RecordVal, len(rec)+extra)
+	for k, v := range rec {
+		res[k] = v
+	}
+	return res
+}
+
+// keys(r)
+// returns a list of field names of r in ascending order.
+// Fails if r is not a record.
+func keysFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	rec, ok := args[0].(store.RecordVal)
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	res := make(store.ListVal, 0, len(rec))
+	for _, k := range sortedKeys(rec) {
+		res = append(res, k)
+	}
+	return res, nil
+}
+
+// values(r)
+// returns a list of field values of r ordered by field names (as returned by keys).
+// Fails if r is not a record.
+func valuesFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, errPrepareFailed
+	}
+	rec, ok := args[0].(store.RecordVal)
+	if !ok {
+		return nil, errPrepareFailed
+	}
+	res := make(store.ListVal, 0, len(rec))
+	for _, k := range sortedKeys(rec) {
+		res = append(res, rec[k])
+	}
+	return res, nil
+}
+
+// has(r,s)
+// returns "" if r has field s, and "0" if it does not.
+// Fails if r is not a record or s is not a string.
+func hasFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	rec, ok1 := args[0].(store.RecordVal)
+	key, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	_, found := rec[key]
+	return boolResult(found), nil
+}
+
+// get(r,s,<value>)
+// returns the value of field s of r, or <value> if r has no such field.
+// Fails if r is not a record or s is not a string.
+func getFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	rec, ok1 := args[0].(store.RecordVal)
+	key, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	if val, found := rec[key]; found {
+		return val, nil
+	}
+	return args[2], nil
+}
+
+// merge(r1,r2)
+// returns a new record with fields of both r1 and r2 (if field exists in both, value of r2 is used).
+// Fails if r1 or r2 is not a record.
+func mergeFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	rec1, ok1 := args[0].(store.RecordVal)
+	rec2, ok2 := args[1].(store.RecordVal)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	res := copyRecord(rec1, len(rec2))
+	for k, v := range rec2 {
+		res[k] = v
+	}
+	return res, nil
+}
+
+// without(r,s)
+// returns a new record with fields of r except field s.
+// Fails if r is not a record or s is not a string.
+func withoutFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, errPrepareFailed
+	}
+	rec, ok1 := args[0].(store.RecordVal)
+	key, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return nil, errPrepareFailed
+	}
+	res := copyRecord(rec, 0)
+	delete(res, key)
+	return res, nil
+}
+
+// setfield(r,s1,s2)
+// returns a new record with fields of r where field s1 is set to s2 (the field is added if missing).
+// Fails if r is not a record, or s1 or s2 is not a string (records may only contain strings).
+func setfieldFunc(args parser.ArgsType) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, errPrepareFailed
+	}
+	rec, ok1 := args[0].(store.RecordVal)
+	key, ok2 := args[1].(string)
+	val, ok3 := args[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, errPrepareFailed
+	}
+	res := copyRecord(rec, 1)
+	res[key] = val
+	return res, nil
+}
+
+// truncates string to 65535 characters (if it exceeds that length)
+func truncate(s string) string {
+	if len(s) > store.MaxStringLength {
+		return s[:store.MaxStringLength]
+	}
+	return s
+}
+
+// converts condition to the string result ("" for true and "0" for false)
+func boolResult(cond bool) string {
+	if cond {
+		return ""
+	}
+	return "0"
+}
+
+// numeric functions
+
+// returns both arguments as numbers
+// fails if there are not exactly two arguments or any of them is not a number
+func numberArgs(args parser.ArgsType) (int64, int64, error) {
+	if len(args) != 2 {
+		return 0, 0, errPrepareFailed
+	}
+	n1, ok1 := args[0].(store.NumberVal)
+	n2, ok2 := args[1].(store.NumberVal)
+	if !ok1 || !ok2 {
+		return 0, 0, errPrepareFailed
+	}
+	return int64(n1), int64(n2), nil
+}
+
+// add(n1,n2)
+// returns the sum of n1 and n2.
+// Fails if n1 or n2 is not a number or the result overflows.
+func addFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	res := n1 + n2
+	if (n2 > 0 && res < n1) || (n2 < 0 && res > n1) {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(res), nil
+}
+
+// sub(n1,n2)
+// returns the difference of n1 and n2.
+// Fails if n1 or n2 is not a number or the result overflows.
+func subFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	res := n1 - n2
+	if (n2 > 0 && res > n1) || (n2 < 0 && res < n1) {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(res), nil
+}
+
+// mul(n1,n2)
+// returns the product of n1 and n2.
+// Fails if n1 or n2 is not a number or the result overflows.
+func mulFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if n1 == 0 || n2 == 0 {
+		return store.NumberVal(0), nil
+	}
+	res := n1 * n2
+	if res/n2 != n1 || (n1 == math.MinInt64 && n2 == -1) {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(res), nil
+}
+
+// div(n1,n2)
+// returns the quotient of n1 and n2 truncated towards zero.
+// Fails if n1 or n2 is not a number, n2 is zero or the result overflows.
+func divFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if n2 == 0 || (n1 == math.MinInt64 && n2 == -1) {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(n1 / n2), nil
+}
+
+// mod(n1,n2)
+// returns the remainder of dividing n1 by n2 (has the sign of n1).
+// Fails if n1 or n2 is not a number or n2 is zero.
+func modFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if n2 == 0 {
+		return nil, errPrepareFailed
+	}
+	return store.NumberVal(n1 % n2), nil
+}
+
+// lt(n1,n2)
+// returns "" if n1 is less than n2, and "0" otherwise.
+// Fails if n1 or n2 is not a number.
+func ltFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if n1 < n2 {
+		return "", nil
+	}
+	return "0", nil
+}
+
+// gt(n1,n2)
+// returns "" if n1 is greater than n2, and "0" otherwise.
+// Fails if n1 or n2 is not a number.
+func gtFunc(args parser.ArgsType) (interface{}, error) {
+	n1, n2, err := numberArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if n1 > n2 {
+		return "", nil
+	}
+	return "0", nil
+}
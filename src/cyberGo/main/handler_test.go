@@ -1,6 +1,9 @@
 package main
 
 import (
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 
 	"cyberGo/parser"
@@ -139,3 +142,264 @@ func TestPrepareRecordRecordIdentifier(t *testing.T) {
 func TestPrepareRecordFieldVarIdentifier(t *testing.T) {
 	// TODO:
 }
+
+// function call test case, fn(args) should return result or fail with error if fail is set
+type functionCase struct {
+	name   string
+	fn     function
+	args   parser.ArgsType
+	result interface{}
+	fail   bool
+}
+
+func checkFunctions(t *testing.T, cases []functionCase) {
+	for _, c := range cases {
+		res, err := c.fn(c.args)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail, got %v", c.name, res)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if !reflect.DeepEqual(res, c.result) {
+			t.Errorf("%s: %v != %v", c.name, res, c.result)
+		}
+	}
+}
+
+func TestArithmeticFunctions(t *testing.T) {
+	checkFunctions(t, []functionCase{
+		{"add", addFunc, parser.ArgsType{store.NumberVal(2), store.NumberVal(3)}, store.NumberVal(5), false},
+		{"add overflow", addFunc, parser.ArgsType{store.NumberVal(math.MaxInt64), store.NumberVal(1)}, nil, true},
+		{"add string", addFunc, parser.ArgsType{"2", store.NumberVal(3)}, nil, true},
+		{"sub", subFunc, parser.ArgsType{store.NumberVal(2), store.NumberVal(3)}, store.NumberVal(-1), false},
+		{"sub overflow", subFunc, parser.ArgsType{store.NumberVal(math.MinInt64), store.NumberVal(1)}, nil, true},
+		{"mul", mulFunc, parser.ArgsType{store.NumberVal(-4), store.NumberVal(3)}, store.NumberVal(-12), false},
+		{"mul overflow", mulFunc, parser.ArgsType{store.NumberVal(math.MaxInt64), store.NumberVal(2)}, nil, true},
+		{"mul min overflow", mulFunc, parser.ArgsType{store.NumberVal(math.MinInt64), store.NumberVal(-1)}, nil, true},
+		{"div", divFunc, parser.ArgsType{store.NumberVal(7), store.NumberVal(2)}, store.NumberVal(3), false},
+		{"div by zero", divFunc, parser.ArgsType{store.NumberVal(7), store.NumberVal(0)}, nil, true},
+		{"div overflow", divFunc, parser.ArgsType{store.NumberVal(math.MinInt64), store.NumberVal(-1)}, nil, true},
+		{"mod", modFunc, parser.ArgsType{store.NumberVal(-7), store.NumberVal(3)}, store.NumberVal(-1), false},
+		{"mod by zero", modFunc, parser.ArgsType{store.NumberVal(7), store.NumberVal(0)}, nil, true},
+		{"lt true", ltFunc, parser.ArgsType{store.NumberVal(1), store.NumberVal(2)}, "", false},
+		{"lt false", ltFunc, parser.ArgsType{store.NumberVal(2), store.NumberVal(2)}, "0", false},
+		{"gt true", gtFunc, parser.ArgsType{store.NumberVal(3), store.NumberVal(2)}, "", false},
+		{"gt record", gtFunc, parser.ArgsType{store.RecordVal{}, store.NumberVal(2)}, nil, true},
+		{"equal numbers", equalFunc, parser.ArgsType{store.NumberVal(3), store.NumberVal(3)}, "", false},
+		{"equal number and string", equalFunc, parser.ArgsType{store.NumberVal(3), "3"}, nil, true},
+	})
+}
+
+func TestStringFunctions(t *testing.T) {
+	long := strings.Repeat("a", 65535)
+	checkFunctions(t, []functionCase{
+		{"toupper", toupperFunc, parser.ArgsType{"abC1"}, "ABC1", false},
+		{"toupper list", toupperFunc, parser.ArgsType{store.ListVal{}}, nil, true},
+		{"length", lengthFunc, parser.ArgsType{"abc"}, store.NumberVal(3), false},
+		{"substring", substringFunc, parser.ArgsType{"abcdef", store.NumberVal(1), store.NumberVal(3)}, "bc", false},
+		{"substring out of range", substringFunc, parser.ArgsType{"abc", store.NumberVal(2), store.NumberVal(10)}, "c", false},
+		{"substring negative", substringFunc, parser.ArgsType{"abc", store.NumberVal(-1), store.NumberVal(2)}, nil, true},
+		{"substring string index", substringFunc, parser.ArgsType{"abc", "1", store.NumberVal(2)}, nil, true},
+		{"replace", replaceFunc, parser.ArgsType{"a-b-c", "-", "+"}, "a+b+c", false},
+		{"replace truncated", replaceFunc, parser.ArgsType{long, "a", "bb"}, strings.Repeat("b", 65535), false},
+		{"contains", containsFunc, parser.ArgsType{"abc", "bc"}, "", false},
+		{"not contains", containsFunc, parser.ArgsType{"abc", "d"}, "0", false},
+		{"startswith", startswithFunc, parser.ArgsType{"abc", "ab"}, "", false},
+		{"endswith", endswithFunc, parser.ArgsType{"abc", "ab"}, "0", false},
+		{"trim", trimFunc, parser.ArgsType{"  a b "}, "a b", false},
+		{"indexof", indexofFunc, parser.ArgsType{"abcb", "b"}, store.NumberVal(1), false},
+		{"indexof not found", indexofFunc, parser.ArgsType{"abc", "d"}, store.NumberVal(-1), false},
+		{"repeat", repeatFunc, parser.ArgsType{"ab", store.NumberVal(3)}, "ababab", false},
+		{"repeat truncated", repeatFunc, parser.ArgsType{"ab", store.NumberVal(1000000)}, strings.Repeat("ab", 32767) + "a", false},
+		{"repeat negative", repeatFunc, parser.ArgsType{"ab", store.NumberVal(-1)}, nil, true},
+		{"splitby", splitbyFunc, parser.ArgsType{"a,b,,c", ","}, store.ListVal{"a", "b", "", "c"}, false},
+		{"splitby record", splitbyFunc, parser.ArgsType{store.RecordVal{}, ","}, nil, true},
+	})
+}
+
+func TestListFunctions(t *testing.T) {
+	lst := store.ListVal{"b", store.ListVal{"a", "c"}, "a"}
+	checkFunctions(t, []functionCase{
+		{"len", lenFunc, parser.ArgsType{lst}, store.NumberVal(4), false},
+		{"len string", lenFunc, parser.ArgsType{"abc"}, nil, true},
+		{"first", firstFunc, parser.ArgsType{lst}, "b", false},
+		{"first empty", firstFunc, parser.ArgsType{store.ListVal{store.ListVal{}}}, nil, true},
+		{"last", lastFunc, parser.ArgsType{lst}, "a", false},
+		{"nth", nthFunc, parser.ArgsType{lst, store.NumberVal(2)}, "c", false},
+		{"nth out of range", nthFunc, parser.ArgsType{lst, store.NumberVal(4)}, nil, true},
+		{"slice", sliceFunc, parser.ArgsType{lst, store.NumberVal(1), store.NumberVal(3)}, store.ListVal{"a", "c"}, false},
+		{"slice negative", sliceFunc, parser.ArgsType{lst, store.NumberVal(-3), store.NumberVal(-1)}, store.ListVal{"a", "c"}, false},
+		{"slice empty", sliceFunc, parser.ArgsType{lst, store.NumberVal(4), store.NumberVal(4)}, store.ListVal{}, false},
+		{"slice out of range", sliceFunc, parser.ArgsType{lst, store.NumberVal(3), store.NumberVal(5)}, nil, true},
+		{"slice negative out of range", sliceFunc, parser.ArgsType{lst, store.NumberVal(-5), store.NumberVal(2)}, nil, true},
+		{"reversed slice", sliceFunc, parser.ArgsType{lst, store.NumberVal(3), store.NumberVal(1)}, nil, true},
+		{"reverse", reverseFunc, parser.ArgsType{lst}, store.ListVal{"a", "c", "a", "b"}, false},
+		{"contains", containsFunc, parser.ArgsType{lst, "c"}, "", false},
+		{"contains record", containsFunc, parser.ArgsType{store.ListVal{store.RecordVal{"x": "y"}}, store.RecordVal{"x": "y"}}, "", false},
+		{"not contains", containsFunc, parser.ArgsType{lst, "d"}, "0", false},
+		{"unique", uniqueFunc, parser.ArgsType{lst}, store.ListVal{"b", "a", "c"}, false},
+		{"sort strings", sortFunc, parser.ArgsType{lst}, store.ListVal{"a", "a", "b", "c"}, false},
+		{"sort numbers", sortFunc, parser.ArgsType{store.ListVal{store.NumberVal(3), store.NumberVal(-1)}}, store.ListVal{store.NumberVal(-1), store.NumberVal(3)}, false},
+		{"sort mixed", sortFunc, parser.ArgsType{store.ListVal{"a", store.NumberVal(-1)}}, nil, true},
+		{"join", joinFunc, parser.ArgsType{lst, ", "}, "b, a, c, a", false},
+		{"join records", joinFunc, parser.ArgsType{store.ListVal{store.RecordVal{}}, ","}, nil, true},
+		{"flatten", flattenFunc, parser.ArgsType{lst}, store.ListVal{"b", "a", "c", "a"}, false},
+	})
+	if !reflect.DeepEqual(lst, store.ListVal{"b", store.ListVal{"a", "c"}, "a"}) {
+		t.Errorf("Source list should not be changed: %v", lst)
+	}
+}
+
+func TestRecordFunctions(t *testing.T) {
+	rec := store.RecordVal{"b": "2", "a": "1"}
+	checkFunctions(t, []functionCase{
+		{"keys", keysFunc, parser.ArgsType{rec}, store.ListVal{"a", "b"}, false},
+		{"keys list", keysFunc, parser.ArgsType{store.ListVal{}}, nil, true},
+		{"values", valuesFunc, parser.ArgsType{rec}, store.ListVal{"1", "2"}, false},
+		{"has", hasFunc, parser.ArgsType{rec, "a"}, "", false},
+		{"has missing", hasFunc, parser.ArgsType{rec, "c"}, "0", false},
+		{"get", getFunc, parser.ArgsType{rec, "a", "x"}, "1", false},
+		{"get default", getFunc, parser.ArgsType{rec, "c", "x"}, "x", false},
+		{"merge", mergeFunc, parser.ArgsType{rec, store.RecordVal{"b": "3", "c": "4"}}, store.RecordVal{"a": "1", "b": "3", "c": "4"}, false},
+		{"merge string", mergeFunc, parser.ArgsType{rec, "c"}, nil, true},
+		{"without", withoutFunc, parser.ArgsType{rec, "a"}, store.RecordVal{"b": "2"}, false},
+		{"setfield", setfieldFunc, parser.ArgsType{rec, "c", "3"}, store.RecordVal{"a": "1", "b": "2", "c": "3"}, false},
+		{"setfield list", setfieldFunc, parser.ArgsType{rec, "c", store.ListVal{}}, nil, true},
+	})
+	if len(rec) != 2 {
+		t.Errorf("Source record should not be changed: %v", rec)
+	}
+}
+
+func TestPrepareMapFilter(t *testing.T) {
+	s := store.NewStore("admin")
+	ls, _ := s.AsPrincipal("admin", "admin")
+	ls.Set("x", store.ListVal{"a", store.ListVal{"b", "c"}})
+	h := Handler{ls: ls}
+
+	val, err := h.prepareValue(parser.Map{
+		Var:  "y",
+		List: parser.Identifier("x"),
+		Expr: parser.Function{Name: "concat", Args: parser.ArgsType{parser.Identifier("y"), "!"}},
+	}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error for prepare map: %v", err)
+	}
+	if !reflect.DeepEqual(val, store.ListVal{"a!", "b!", "c!"}) {
+		t.Errorf("Wrong value for prepared map: %v", val)
+	}
+
+	val, err = h.prepareValue(parser.Filter{
+		Var:  "y",
+		List: parser.Identifier("x"),
+		Cond: parser.Function{Name: "notequal", Args: parser.ArgsType{parser.Identifier("y"), "b"}},
+	}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error for prepare filter: %v", err)
+	}
+	if !reflect.DeepEqual(val, store.ListVal{"a", "c"}) {
+		t.Errorf("Wrong value for prepared filter: %v", val)
+	}
+	if v, _ := ls.Get("x"); len(v.(store.ListVal)) != 2 {
+		t.Errorf("Source list should not be changed: %v", v)
+	}
+
+	if _, err = h.prepareValue(parser.Map{Var: "x", List: parser.Identifier("x"), Expr: "a"}, nil); err == nil {
+		t.Errorf("Map should fail if variable already exists")
+	}
+	if _, err = h.prepareValue(parser.Map{Var: "y", List: "abc", Expr: "a"}, nil); err == nil {
+		t.Errorf("Map should fail for non-list")
+	}
+}
+
+func TestPreparePermissions(t *testing.T) {
+	s := store.NewStore("admin")
+	ls, _ := s.AsPrincipal("admin", "admin")
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", "a")
+	ls.SetDelegation("x", "admin", store.PermissionRead, "alice")
+	h := Handler{ls: ls}
+
+	cmd := parser.Parse(`return map r in filter r in permissions(x) where equal(r.right, "read") => r.user`)
+	val, err := h.prepareValue(cmd.Args[0], nil)
+	if err != nil || !reflect.DeepEqual(val, store.ListVal{"admin", "alice"}) {
+		t.Errorf("Wrong readers from permissions: %v %v", val, err)
+	}
+}
+
+func TestForeachInto(t *testing.T) {
+	s := store.NewStore("admin")
+	ls, _ := s.AsPrincipal("admin", "admin")
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", store.ListVal{"a", "b"})
+	ls.SetDelegation("x", "admin", store.PermissionRead, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	h := Handler{ls: ls}
+	expr := parser.Function{Name: "concat", Args: parser.ArgsType{parser.Identifier("y"), "!"}}
+	cmd := parser.Cmd{Type: parser.CmdForeach, Args: parser.ArgsType{parser.Identifier("y"), parser.Identifier("x"), expr}}
+	if res := h.cmdForeach(&cmd); res != statusDenied {
+		t.Errorf("Foreach without write permission should be denied: %v", res)
+	}
+	cmd.Args = append(cmd.Args, parser.Identifier("z"))
+	if res := h.cmdForeach(&cmd); res.Status != "FOREACH" {
+		t.Errorf("Foreach into new variable should succeed: %v", res)
+	}
+	cond := parser.Function{Name: "equal", Args: parser.ArgsType{parser.Identifier("y"), "a"}}
+	cmd = parser.Cmd{Type: parser.CmdFiltereach, Args: parser.ArgsType{parser.Identifier("y"), parser.Identifier("x"), cond, parser.Identifier("w")}}
+	if res := h.cmdFiltereach(&cmd); res.Status != "FILTEREACH" {
+		t.Errorf("Filtereach into new variable should succeed: %v", res)
+	}
+	if v, _ := ls.Get("x"); !reflect.DeepEqual(v, store.ListVal{"a", "b"}) {
+		t.Errorf("Source list should not be changed: %v", v)
+	}
+	if v, _ := ls.Get("z"); !reflect.DeepEqual(v, store.ListVal{"a!", "b!"}) {
+		t.Errorf("Invalid foreach result: %v", v)
+	}
+	if v, _ := ls.Get("w"); !reflect.DeepEqual(v, store.ListVal{"a"}) {
+		t.Errorf("Invalid filtereach result: %v", v)
+	}
+}
+
+func TestPrepareIndexSlice(t *testing.T) {
+	s := store.NewStore("admin")
+	ls, _ := s.AsPrincipal("admin", "admin")
+	ls.Set("x", store.ListVal{"a", store.ListVal{"b", "c"}, "d"})
+	h := Handler{ls: ls}
+
+	one, two, four, five := parser.Number(1), parser.Number(2), parser.Number(4), parser.Number(5)
+	minusOne, minusFive := parser.Number(-1), parser.Number(-5)
+	cases := []struct {
+		name   string
+		in     interface{}
+		result interface{}
+		fail   bool
+	}{
+		{"index", parser.Index{Var: "x", Index: 1}, "b", false},
+		{"negative index", parser.Index{Var: "x", Index: -1}, "d", false},
+		{"index out of range", parser.Index{Var: "x", Index: 4}, nil, true},
+		{"negative index out of range", parser.Index{Var: "x", Index: -5}, nil, true},
+		{"index of missing variable", parser.Index{Var: "y", Index: 0}, nil, true},
+		{"slice", parser.Slice{Var: "x", Low: &one, High: &two}, store.ListVal{"b"}, false},
+		{"open slice", parser.Slice{Var: "x", Low: &minusOne}, store.ListVal{"d"}, false},
+		{"full slice", parser.Slice{Var: "x"}, store.ListVal{"a", "b", "c", "d"}, false},
+		{"empty slice at end", parser.Slice{Var: "x", Low: &four}, store.ListVal{}, false},
+		{"slice out of range", parser.Slice{Var: "x", High: &five}, nil, true},
+		{"negative slice out of range", parser.Slice{Var: "x", Low: &minusFive}, nil, true},
+		{"reversed slice", parser.Slice{Var: "x", Low: &two, High: &one}, nil, true},
+	}
+	for _, c := range cases {
+		res, err := h.prepareValue(c.in, nil)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: should fail, got %v", c.name, res)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if !reflect.DeepEqual(res, c.result) {
+			t.Errorf("%s: %v != %v", c.name, res, c.result)
+		}
+	}
+}
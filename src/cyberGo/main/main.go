@@ -16,6 +16,8 @@ var (
 	adminPassword string = "admin" //default admin pass
 )
 
+const defaultMaxStoreSize = 1 << 30 // limit of the total size of variables in bytes
+
 // Signal handler to catch SIGTERM signal and exit with 0 code as task require
 func signalHandler() {
 	c := make(chan os.Signal, 1)
@@ -66,6 +68,46 @@ func checkArgs(params []string) {
 	}
 }
 
+// Default quotas of principals are configured by environment variables, 0 or unset means unlimited:
+// QUOTA_VARIABLES - number of global variables created by a principal
+// QUOTA_BYTES - total size of values of variables created by a principal
+// QUOTA_LENGTH - length of lists stored in variables created by a principal
+// Admin may change quotas of a principal with 'set quota' command.
+var quotaEnv = map[string]store.QuotaKind{
+	"QUOTA_VARIABLES": store.QuotaVariables,
+	"QUOTA_BYTES":     store.QuotaBytes,
+	"QUOTA_LENGTH":    store.QuotaLength,
+}
+
+func setDefaultQuotas(s *store.Store) {
+	for env, kind := range quotaEnv {
+		val := os.Getenv(env)
+		if val == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			log.Println("Wrong quota", env)
+			os.Exit(255)
+		}
+		s.SetDefaultQuota(kind, n)
+	}
+}
+
+// Limit of the total size of variables may be changed by STORE_MAX_SIZE environment variable, 0 means unlimited
+func setMaxStoreSize(s *store.Store) {
+	n := int64(defaultMaxStoreSize)
+	if val := os.Getenv("STORE_MAX_SIZE"); val != "" {
+		var err error
+		n, err = strconv.ParseInt(val, 10, 64)
+		if err != nil || n < 0 {
+			log.Println("Wrong store size limit")
+			os.Exit(255)
+		}
+	}
+	s.SetMaxSize(n)
+}
+
 func main() {
 	params := os.Args[1:]
 
@@ -76,6 +118,8 @@ func main() {
 
 	// Initialize global store
 	store := store.NewStore(adminPassword)
+	setDefaultQuotas(store)
+	setMaxStoreSize(store)
 
 	// Listen for incoming connections.
 	l, err := net.Listen("tcp", ":"+strconv.Itoa(portNumber))
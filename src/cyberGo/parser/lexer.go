@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -54,6 +55,34 @@ const (
 	tokenDelegate                       // 'delegate' keyword
 	tokenFiltereach                     // 'filtereach' keyword
 	tokenLet                            // 'let' keyword
+	tokenNumber                         // number constant
+	tokenMap                            // 'map' keyword
+	tokenFilter                         // 'filter' keyword
+	tokenWhere                          // 'where' keyword
+	tokenFatArrow                       // '=>' token
+	tokenInto                           // 'into' keyword
+	tokenColon                          // ':' keyword
+	tokenRemove                         // 'remove' keyword
+	tokenFrom                           // 'from' keyword
+	tokenPop                            // 'pop' keyword
+	tokenPrepend                        // 'prepend' keyword
+	tokenDisable                        // 'disable' keyword
+	tokenEnable                         // 'enable' keyword
+	tokenRename                         // 'rename' keyword
+	tokenCopy                           // 'copy' keyword
+	tokenDelegations                    // 'delegations' keyword
+	tokenExport                         // 'export' keyword
+	tokenOn                             // 'on' keyword
+	tokenGroup                          // 'group' keyword
+	tokenFor                            // 'for' keyword
+	tokenUntil                          // 'until' keyword
+	tokenDenial                         // 'denial' keyword
+	tokenNamespace                      // 'namespace' keyword
+	tokenCreation                       // 'creation' keyword
+	tokenGrant                          // 'grant' keyword
+	tokenRevoke                         // 'revoke' keyword
+	tokenCapability                     // 'capability' keyword
+	tokenQuota                          // 'quota' keyword
 	tokenComment                        // comment
 )
 
@@ -99,6 +128,34 @@ var tokens = [...]string{
 	"delegate",
 	"filtereach",
 	"let",
+	"number",
+	"map",
+	"filter",
+	"where",
+	"fatArrow",
+	"into",
+	"colon",
+	"remove",
+	"from",
+	"pop",
+	"prepend",
+	"disable",
+	"enable",
+	"rename",
+	"copy",
+	"delegations",
+	"export",
+	"on",
+	"group",
+	"for",
+	"until",
+	"denial",
+	"namespace",
+	"creation",
+	"grant",
+	"revoke",
+	"capability",
+	"quota",
 	"comment",
 }
 
@@ -133,6 +190,46 @@ var keywordsMap = map[string]tokenType{
 	"let":         tokenLet,
 }
 
+// Contextual keywords are lexed as identifiers, so they may still be used as variable names.
+// The parser recognizes them only where the grammar expects a keyword, see keyword.
+var contextualKeywordsMap = map[string]tokenType{
+	"map":         tokenMap,
+	"filter":      tokenFilter,
+	"where":       tokenWhere,
+	"into":        tokenInto,
+	"remove":      tokenRemove,
+	"from":        tokenFrom,
+	"pop":         tokenPop,
+	"prepend":     tokenPrepend,
+	"disable":     tokenDisable,
+	"enable":      tokenEnable,
+	"rename":      tokenRename,
+	"copy":        tokenCopy,
+	"delegations": tokenDelegations,
+	"export":      tokenExport,
+	"on":          tokenOn,
+	"group":       tokenGroup,
+	"for":         tokenFor,
+	"until":       tokenUntil,
+	"denial":      tokenDenial,
+	"namespace":   tokenNamespace,
+	"creation":    tokenCreation,
+	"grant":       tokenGrant,
+	"revoke":      tokenRevoke,
+	"capability":  tokenCapability,
+	"quota":       tokenQuota,
+}
+
+// returns type of the contextual keyword if tok is an identifier spelled as one, type of tok otherwise
+func keyword(tok token) tokenType {
+	if tok.typ == tokenId {
+		if kw, ok := contextualKeywordsMap[tok.val]; ok {
+			return kw
+		}
+	}
+	return tok.typ
+}
+
 const eof = 0
 const maxString = 65535
 const maxIdentifier = 255
@@ -172,6 +269,13 @@ func (l *lexer) next() token {
 				return token{kw, ""}
 			}
 			return token{tokenId, id}
+		case isDigit(c):
+			l.backup()
+			if n, ok := l.readNumber(); ok {
+				return token{tokenNumber, n}
+			}
+			l.seteof()
+			return token{tokenError, "Invalid number"}
 		case c == '[':
 			return token{tokenLeftSBracket, ""}
 		case c == ']':
@@ -180,6 +284,8 @@ func (l *lexer) next() token {
 			return token{tokenLeftCBracket, ""}
 		case c == '}':
 			return token{tokenRightCBracket, ""}
+		case c == '=' && l.accepts(">"): // =>
+			return token{tokenFatArrow, ""}
 		case c == '=':
 			return token{tokenEquals, ""}
 		case c == '(':
@@ -190,6 +296,15 @@ func (l *lexer) next() token {
 			return token{tokenDot, ""}
 		case c == ',':
 			return token{tokenComma, ""}
+		case c == ':':
+			return token{tokenColon, ""}
+		case c == '-' && !l.eof() && isDigit(l.input[l.pos]): // negative number
+			l.backup()
+			if n, ok := l.readNumber(); ok {
+				return token{tokenNumber, n}
+			}
+			l.seteof()
+			return token{tokenError, "Invalid number"}
 		case c == '-': // ->
 			if c2 := l.nextc(); c2 == '>' {
 				return token{tokenArrow, ""}
@@ -218,6 +333,25 @@ func (l *lexer) next() token {
 	}
 }
 
+// returns next token without moving forward
+func (l *lexer) peek() token {
+	pos := l.pos
+	tok := l.next()
+	l.pos = pos
+	return tok
+}
+
+// returns n-th next token without moving forward, lookahead(1) is the same as peek()
+func (l *lexer) lookahead(n int) token {
+	pos := l.pos
+	var tok token
+	for i := 0; i < n; i++ {
+		tok = l.next()
+	}
+	l.pos = pos
+	return tok
+}
+
 // gets next character from the input
 // since the string is ascii, just use the byte return type
 func (l *lexer) nextc() byte {
@@ -263,6 +397,7 @@ func (l *lexer) readString() (string, bool) {
 }
 
 // reads identifier from input
+// identifier may be namespaced: n::x, each part should start with a letter
 func (l *lexer) readIdentifier() string {
 	if l.eof() {
 		return ""
@@ -270,12 +405,36 @@ func (l *lexer) readIdentifier() string {
 	i := l.pos
 	for i < len(l.input) && isAlphaNumeric(l.input[i]) {
 		i++
+		if strings.HasPrefix(l.input[i:], "::") && i+2 < len(l.input) && isLetter(l.input[i+2]) {
+			i += 2
+		}
 	}
 	s := l.input[l.pos:i]
 	l.pos = i
 	return s
 }
 
+// reads decimal number (with optional leading '-') from input
+// number should fit into int64 and should not be followed by a letter
+func (l *lexer) readNumber() (string, bool) {
+	i := l.pos
+	if i < len(l.input) && l.input[i] == '-' {
+		i++
+	}
+	for i < len(l.input) && isDigit(l.input[i]) {
+		i++
+	}
+	s := l.input[l.pos:i]
+	l.pos = i
+	if i < len(l.input) && isAlphaNumeric(l.input[i]) {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
 // reads to the end of input and trim spaces
 func (l *lexer) tail() string {
 	if l.eof() {
@@ -314,7 +473,12 @@ func isLetter(ch byte) bool {
 	return ('a' <= ch && ch <= 'z') || ('A' <= ch && ch <= 'Z')
 }
 
+// checks symbol is decimal digit
+func isDigit(ch byte) bool {
+	return '0' <= ch && ch <= '9'
+}
+
 // checks symbol is alphanumeric or _
 func isAlphaNumeric(ch byte) bool {
-	return isLetter(ch) || ('0' <= ch && ch <= '9') || (ch == '_')
+	return isLetter(ch) || isDigit(ch) || (ch == '_')
 }
@@ -125,6 +125,39 @@ func TestNext(t *testing.T) {
 			{typ: tokenError},
 		}},
 		{"Invalid symbols in input", "*abc", []token{{typ: tokenError}}},
+		{"Numbers", `set x = add(12,-3)`, []token{
+			{typ: tokenSet},
+			{typ: tokenId, val: "x"},
+			{typ: tokenEquals},
+			{typ: tokenId, val: "add"},
+			{typ: tokenLeftParen},
+			{typ: tokenNumber, val: "12"},
+			{typ: tokenComma},
+			{typ: tokenNumber, val: "-3"},
+			{typ: tokenRightParen},
+		}},
+		{"Map expression", `map y in x => y`, []token{
+			{typ: tokenId, val: "map"},
+			{typ: tokenId, val: "y"},
+			{typ: tokenIn},
+			{typ: tokenId, val: "x"},
+			{typ: tokenFatArrow},
+			{typ: tokenId, val: "y"},
+		}},
+		{"Namespaced identifier", `set team::x = y`, []token{
+			{typ: tokenSet},
+			{typ: tokenId, val: "team::x"},
+			{typ: tokenEquals},
+			{typ: tokenId, val: "y"},
+		}},
+		{"Namespace separator without name", `team::1`, []token{
+			{typ: tokenId, val: "team"},
+			{typ: tokenColon},
+			{typ: tokenColon},
+			{typ: tokenNumber, val: "1"},
+		}},
+		{"Number overflow", "99999999999999999999", []token{{typ: tokenError}}},
+		{"Number followed by letter", "12abc", []token{{typ: tokenError}}},
 	}
 	for _, c := range cases {
 		lex := newLexer(c.in)
@@ -1,6 +1,11 @@
 package parser
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type CmdType int
 
@@ -21,6 +26,30 @@ const (
 	CmdDeleteDelegation // 'delete delegation' command
 	CmdDefaultDelegator // 'default delegator' command
 	CmdTerminate        // '***' command
+	CmdSetField         // 'set x.f' command
+	CmdAppendToField    // 'append to x.f' command
+	CmdSetIndex         // 'set x[i]' command
+	CmdRemoveFrom       // 'remove from' command
+	CmdPop              // 'pop' command
+	CmdPrependTo        // 'prepend to' command
+	CmdDeleteVar        // 'delete x' command
+	CmdDeletePrincipal  // 'delete principal' command
+	CmdDisablePrincipal // 'disable principal' command
+	CmdEnablePrincipal  // 'enable principal' command
+	CmdRenameVar        // 'rename x to y' command
+	CmdCopyVar          // 'copy x to y' command
+	CmdCopyDelegations  // 'copy x to y with delegations' command
+	CmdExport           // 'export delegations' command
+	CmdCreateGroup      // 'create group' command
+	CmdAddToGroup       // 'add p to group g' command
+	CmdRemoveFromGroup  // 'remove p from group g' command
+	CmdSetDenial        // 'set denial' command
+	CmdDeleteDenial     // 'delete denial' command
+	CmdCreateNamespace  // 'create namespace' command
+	CmdDefaultCreation  // 'default creation' command
+	CmdGrantCapability  // 'grant capability' command
+	CmdRevokeCapability // 'revoke capability' command
+	CmdSetQuota         // 'set quota' command
 )
 
 var cmds = [...]string{
@@ -40,11 +69,36 @@ var cmds = [...]string{
 	"deleteDelegation",
 	"defaultDelegator",
 	"***",
+	"setField",
+	"appendToField",
+	"setIndex",
+	"removeFrom",
+	"pop",
+	"prependTo",
+	"deleteVar",
+	"deletePrincipal",
+	"disablePrincipal",
+	"enablePrincipal",
+	"renameVar",
+	"copyVar",
+	"copyDelegations",
+	"export",
+	"createGroup",
+	"addToGroup",
+	"removeFromGroup",
+	"setDenial",
+	"deleteDenial",
+	"createNamespace",
+	"defaultCreation",
+	"grantCapability",
+	"revokeCapability",
+	"setQuota",
 }
 
 func (t CmdType) String() string { return cmds[t] }
 
 type Identifier string
+type Number int64
 type Record map[string]interface{}
 type List []interface{}
 type FieldVal struct{ Rec, Key string }
@@ -52,11 +106,36 @@ type Function struct {
 	Name string
 	Args ArgsType
 }
+
+// Expiration of delegation, For duration from now or Until time
+type Expiry struct {
+	For   time.Duration
+	Until time.Time
+}
 type Let struct {
 	Var   string
 	Left  interface{}
 	Right interface{}
 }
+type Map struct {
+	Var  string
+	List interface{}
+	Expr interface{}
+}
+type Index struct {
+	Var   string
+	Index Number
+}
+type Slice struct {
+	Var  string
+	Low  *Number // nil if omitted
+	High *Number // nil if omitted
+}
+type Filter struct {
+	Var  string
+	List interface{}
+	Cond interface{}
+}
 
 type ArgsType []interface{}
 
@@ -72,7 +151,7 @@ func Parse(line string) Cmd {
 	if tok.typ == tokenComment {
 		tok = lex.next()
 	}
-	switch tok.typ {
+	switch keyword(tok) {
 	case tokenAs:
 		cmd = parseAsPrincipal(lex)
 	case tokenExit:
@@ -80,23 +159,50 @@ func Parse(line string) Cmd {
 	case tokenReturn:
 		cmd = parseReturn(lex)
 	case tokenCreate:
-		cmd = parseCreatePrincipal(lex)
+		cmd = parseCreate(lex)
 	case tokenChange:
 		cmd = parseChangePassword(lex)
 	case tokenSet: // set variable or delegation
 		cmd = parseSet(lex)
 	case tokenAppend:
 		cmd = parseAppend(lex)
+	case tokenRemove:
+		cmd = parseRemove(lex)
+	case tokenPop:
+		cmd = parsePop(lex)
+	case tokenPrepend:
+		cmd = parsePrepend(lex)
 	case tokenLocal:
 		cmd = parseLocal(lex)
 	case tokenForeach:
 		cmd = parseForeach(lex)
 	case tokenFiltereach:
 		cmd = parseFiltereach(lex)
-	case tokenDelete:
-		cmd = parseDeleteDelegation(lex)
+	case tokenDelete: // delete variable or delegation
+		cmd = parseDelete(lex)
+	case tokenRename:
+		cmd = parseRename(lex)
+	case tokenCopy:
+		cmd = parseCopy(lex)
+	case tokenExport:
+		cmd = parseExport(lex)
+	case tokenGrant:
+		cmd = parseCapability(lex, CmdGrantCapability, tokenTo)
+	case tokenRevoke:
+		cmd = parseCapability(lex, CmdRevokeCapability, tokenFrom)
+	case tokenDisable:
+		cmd = parsePrincipalCmd(lex, CmdDisablePrincipal)
+	case tokenEnable:
+		cmd = parsePrincipalCmd(lex, CmdEnablePrincipal)
 	case tokenDefault:
 		cmd = parseDefaultDelegator(lex)
+	case tokenId:
+		// add is not a keyword to keep add(x, y) function
+		if tok.val == "add" {
+			cmd = parseAddToGroup(lex)
+		} else {
+			cmd = Cmd{CmdError, ArgsType{fmt.Sprintf("Unexpeted token: %v", tok.typ)}}
+		}
 	case tokenEnd:
 		cmd = Cmd{CmdEmpty, nil}
 	case tokenTerminate:
@@ -155,13 +261,23 @@ func parseReturn(lex *lexer) Cmd {
 	return cmd
 }
 
-func parseCreatePrincipal(lex *lexer) Cmd {
-	cmd := Cmd{CmdCreatePrincipal, make(ArgsType, 2)}
+// create principal p s, create group g or create namespace n
+func parseCreate(lex *lexer) Cmd {
 	tok := lex.next()
-	if tok.typ != tokenPrincipal {
+	if keyword(tok) == tokenGroup {
+		return parseGroupName(lex, CmdCreateGroup)
+	} else if keyword(tok) == tokenNamespace {
+		return parseNamespaceName(lex)
+	} else if tok.typ != tokenPrincipal {
 		return invalidTokenError(tok.typ, tokenPrincipal)
 	}
-	tok = lex.next()
+	return parseCreatePrincipal(lex)
+}
+
+// create principal p s ('create principal' already parsed)
+func parseCreatePrincipal(lex *lexer) Cmd {
+	cmd := Cmd{CmdCreatePrincipal, make(ArgsType, 2)}
+	tok := lex.next()
 	if tok.typ != tokenId {
 		return invalidTokenError(tok.typ, tokenId)
 	}
@@ -195,14 +311,28 @@ func parseChangePassword(lex *lexer) Cmd {
 
 func parseSet(lex *lexer) Cmd {
 	tok := lex.next()
-	if tok.typ == tokenDelegation {
+	typ := tok.typ
+	if next := lex.peek().typ; next != tokenEquals && next != tokenDot && next != tokenLeftSBracket {
+		typ = keyword(tok) // not an assignment to a variable named as a contextual keyword
+	}
+	if typ == tokenDelegation {
 		return parseSetDelegation(lex)
+	} else if typ == tokenDenial {
+		return parseDenial(lex, CmdSetDenial)
+	} else if typ == tokenQuota {
+		return parseSetQuota(lex)
 	} else if tok.typ != tokenId {
 		return invalidTokenError(tok.typ, tokenId)
 	}
 	cmd := Cmd{CmdSet, make(ArgsType, 2)}
 	cmd.Args[0] = Identifier(tok.val)
 	tok = lex.next()
+	if tok.typ == tokenDot { // set x.f = <expr>
+		return parseSetField(lex, cmd.Args[0])
+	}
+	if tok.typ == tokenLeftSBracket { // set x[i] = <expr>
+		return parseSetIndex(lex, cmd.Args[0])
+	}
 	if tok.typ != tokenEquals {
 		return invalidTokenError(tok.typ, tokenEquals)
 	}
@@ -226,6 +356,184 @@ func parseAppend(lex *lexer) Cmd {
 	}
 	cmd.Args[0] = Identifier(tok.val)
 	tok = lex.next()
+	if tok.typ == tokenDot { // append to x.f with <expr>
+		return parseAppendToField(lex, cmd.Args[0])
+	}
+	if tok.typ != tokenWith {
+		return invalidTokenError(tok.typ, tokenWith)
+	}
+	arg, err := parseExpr(lex)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[1] = arg
+	return cmd
+}
+
+// set quota p <kind> = n ('set quota' already parsed)
+func parseSetQuota(lex *lexer) Cmd {
+	cmd := Cmd{CmdSetQuota, make(ArgsType, 3)}
+	for i := 0; i < 2; i++ {
+		tok := lex.next()
+		if tok.typ != tokenId {
+			return invalidTokenError(tok.typ, tokenId)
+		}
+		cmd.Args[i] = Identifier(tok.val)
+	}
+	tok := lex.next()
+	if tok.typ != tokenEquals {
+		return invalidTokenError(tok.typ, tokenEquals)
+	}
+	tok = lex.next()
+	if tok.typ != tokenNumber {
+		return invalidTokenError(tok.typ, tokenNumber)
+	}
+	n, err := parseNumber(tok.val)
+	if err != nil {
+		return errorCmd(err)
+	}
+	if n < 0 {
+		return errorCmd(fmt.Errorf("Quota must not be negative: %v", n))
+	}
+	cmd.Args[2] = n
+	return cmd
+}
+
+// set x.f = <expr> (x and '.' already parsed)
+func parseSetField(lex *lexer, x interface{}) Cmd {
+	cmd := Cmd{CmdSetField, make(ArgsType, 3)}
+	cmd.Args[0] = x
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[1] = Identifier(tok.val)
+	tok = lex.next()
+	if tok.typ != tokenEquals {
+		return invalidTokenError(tok.typ, tokenEquals)
+	}
+	arg, err := parseExpr(lex)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[2] = arg
+	return cmd
+}
+
+// set x[i] = <expr> (x and '[' already parsed)
+func parseSetIndex(lex *lexer, x interface{}) Cmd {
+	cmd := Cmd{CmdSetIndex, make(ArgsType, 3)}
+	cmd.Args[0] = x
+	tok := lex.next()
+	if tok.typ != tokenNumber {
+		return invalidTokenError(tok.typ, tokenNumber)
+	}
+	n, err := parseNumber(tok.val)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[1] = n
+	tok = lex.next()
+	if tok.typ != tokenRightSBracket {
+		return invalidTokenError(tok.typ, tokenRightSBracket)
+	}
+	tok = lex.next()
+	if tok.typ != tokenEquals {
+		return invalidTokenError(tok.typ, tokenEquals)
+	}
+	arg, err := parseExpr(lex)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[2] = arg
+	return cmd
+}
+
+// append to x.f with <expr> (x and '.' already parsed)
+func parseAppendToField(lex *lexer, x interface{}) Cmd {
+	cmd := Cmd{CmdAppendToField, make(ArgsType, 3)}
+	cmd.Args[0] = x
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[1] = Identifier(tok.val)
+	tok = lex.next()
+	if tok.typ != tokenWith {
+		return invalidTokenError(tok.typ, tokenWith)
+	}
+	arg, err := parseExpr(lex)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[2] = arg
+	return cmd
+}
+
+// remove from x where y => <expr> or remove p from group g
+func parseRemove(lex *lexer) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenFrom)
+	}
+	// principal p may be named from too: remove from from group g
+	if keyword(tok) != tokenFrom || keyword(lex.peek()) == tokenFrom && keyword(lex.lookahead(2)) == tokenGroup {
+		return parseGroupMember(lex, CmdRemoveFromGroup, Identifier(tok.val), tokenFrom)
+	}
+	return parseRemoveFrom(lex)
+}
+
+// remove from x where y => <expr> ('remove from' already parsed)
+func parseRemoveFrom(lex *lexer) Cmd {
+	cmd := Cmd{CmdRemoveFrom, make(ArgsType, 3)}
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[0] = Identifier(tok.val)
+	tok = lex.next()
+	if keyword(tok) != tokenWhere {
+		return invalidTokenError(tok.typ, tokenWhere)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[1] = Identifier(tok.val)
+	tok = lex.next()
+	if tok.typ != tokenFatArrow {
+		return invalidTokenError(tok.typ, tokenFatArrow)
+	}
+	arg, err := parseExpr(lex)
+	if err != nil {
+		return errorCmd(err)
+	}
+	cmd.Args[2] = arg
+	return cmd
+}
+
+// pop x
+func parsePop(lex *lexer) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	return Cmd{CmdPop, ArgsType{Identifier(tok.val)}}
+}
+
+// prepend to x with <expr>
+func parsePrepend(lex *lexer) Cmd {
+	cmd := Cmd{CmdPrependTo, make(ArgsType, 2)}
+	tok := lex.next()
+	if tok.typ != tokenTo {
+		return invalidTokenError(tok.typ, tokenTo)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[0] = Identifier(tok.val)
+	tok = lex.next()
 	if tok.typ != tokenWith {
 		return invalidTokenError(tok.typ, tokenWith)
 	}
@@ -281,7 +589,7 @@ func parseForeach(lex *lexer) Cmd {
 		return errorCmd(err)
 	}
 	cmd.Args[2] = arg
-	return cmd
+	return parseInto(lex, cmd)
 }
 
 func parseFiltereach(lex *lexer) Cmd {
@@ -309,6 +617,20 @@ func parseFiltereach(lex *lexer) Cmd {
 		return errorCmd(err)
 	}
 	cmd.Args[2] = arg
+	return parseInto(lex, cmd)
+}
+
+// parse optional 'into z' part of foreach and filtereach commands
+func parseInto(lex *lexer, cmd Cmd) Cmd {
+	if keyword(lex.peek()) != tokenInto {
+		return cmd
+	}
+	lex.next()
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args = append(cmd.Args, Identifier(tok.val))
 	return cmd
 }
 
@@ -317,14 +639,223 @@ func parseSetDelegation(lex *lexer) Cmd {
 	if args == nil {
 		return Cmd{CmdError, ArgsType{"Failed to parse delegation args"}}
 	}
-	return Cmd{CmdSetDelegation, args}
+	typ := keyword(lex.peek())
+	if typ != tokenFor && typ != tokenUntil {
+		return Cmd{CmdSetDelegation, args}
+	}
+	lex.next()
+	tok := lex.next()
+	if tok.typ != tokenStr {
+		return invalidTokenError(tok.typ, tokenStr)
+	}
+	expiry, err := parseExpiry(typ, tok.val)
+	if err != nil {
+		return errorCmd(err)
+	}
+	return Cmd{CmdSetDelegation, append(args, expiry)}
 }
 
-func parseDeleteDelegation(lex *lexer) Cmd {
+// for "<duration>" or until "<RFC3339 time>" of set delegation command
+func parseExpiry(typ tokenType, val string) (Expiry, error) {
+	if typ == tokenFor {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return Expiry{}, err
+		}
+		if d <= 0 {
+			return Expiry{}, fmt.Errorf("Delegation duration must be positive: %v", val)
+		}
+		return Expiry{For: d}, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return Expiry{}, err
+	}
+	return Expiry{Until: t}, nil
+}
+
+func parseDelete(lex *lexer) Cmd {
 	tok := lex.next()
-	if tok.typ != tokenDelegation {
-		return invalidTokenError(tok.typ, tokenDelegation)
+	typ := tok.typ
+	if next := lex.peek().typ; next != tokenEnd && next != tokenComment {
+		typ = keyword(tok) // not a deletion of a variable named as a contextual keyword
+	}
+	if typ == tokenDelegation {
+		return parseDeleteDelegation(lex)
+	} else if typ == tokenPrincipal {
+		return parsePrincipalName(lex, CmdDeletePrincipal)
+	} else if typ == tokenDenial {
+		return parseDenial(lex, CmdDeleteDenial)
+	} else if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
 	}
+	return Cmd{CmdDeleteVar, ArgsType{Identifier(tok.val)}}
+}
+
+// rename x to y
+func parseRename(lex *lexer) Cmd {
+	return parseVarToVar(lex, CmdRenameVar)
+}
+
+// copy x to y [with delegations]
+func parseCopy(lex *lexer) Cmd {
+	cmd := parseVarToVar(lex, CmdCopyVar)
+	if cmd.Type == CmdError || lex.peek().typ != tokenWith {
+		return cmd
+	}
+	lex.next()
+	tok := lex.next()
+	if keyword(tok) != tokenDelegations {
+		return invalidTokenError(tok.typ, tokenDelegations)
+	}
+	cmd.Type = CmdCopyDelegations
+	return cmd
+}
+
+// parse 'x to y' part of rename and copy commands
+func parseVarToVar(lex *lexer, typ CmdType) Cmd {
+	cmd := Cmd{typ, make(ArgsType, 2)}
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[0] = Identifier(tok.val)
+	tok = lex.next()
+	if tok.typ != tokenTo {
+		return invalidTokenError(tok.typ, tokenTo)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[1] = Identifier(tok.val)
+	return cmd
+}
+
+// export delegations <format> [principal p] [on x]
+// Args are format, principal and variable, nil for omitted filter
+func parseExport(lex *lexer) Cmd {
+	cmd := Cmd{CmdExport, make(ArgsType, 3)}
+	tok := lex.next()
+	if keyword(tok) != tokenDelegations {
+		return invalidTokenError(tok.typ, tokenDelegations)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	cmd.Args[0] = Identifier(tok.val)
+	if lex.peek().typ == tokenPrincipal {
+		lex.next()
+		tok = lex.next()
+		if tok.typ != tokenId {
+			return invalidTokenError(tok.typ, tokenId)
+		}
+		cmd.Args[1] = Identifier(tok.val)
+	}
+	if keyword(lex.peek()) == tokenOn {
+		lex.next()
+		tok = lex.next()
+		if tok.typ != tokenId {
+			return invalidTokenError(tok.typ, tokenId)
+		}
+		cmd.Args[2] = Identifier(tok.val)
+	}
+	return cmd
+}
+
+// add p to group g
+func parseAddToGroup(lex *lexer) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	return parseGroupMember(lex, CmdAddToGroup, Identifier(tok.val), tokenTo)
+}
+
+// parse '<to|from> group g' part of group membership commands, member p already parsed
+func parseGroupMember(lex *lexer, typ CmdType, member Identifier, prep tokenType) Cmd {
+	tok := lex.next()
+	if keyword(tok) != prep {
+		return invalidTokenError(tok.typ, prep)
+	}
+	tok = lex.next()
+	if keyword(tok) != tokenGroup {
+		return invalidTokenError(tok.typ, tokenGroup)
+	}
+	cmd := parseGroupName(lex, typ)
+	if cmd.Type == CmdError {
+		return cmd
+	}
+	return Cmd{typ, ArgsType{member, cmd.Args[0]}}
+}
+
+// create namespace n ('create namespace' already parsed), n can not be namespaced
+func parseNamespaceName(lex *lexer) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	if strings.Contains(tok.val, "::") {
+		return errorCmd(fmt.Errorf("Nested namespace: %v", tok.val))
+	}
+	return Cmd{CmdCreateNamespace, ArgsType{Identifier(tok.val)}}
+}
+
+// parse group name g for group commands
+func parseGroupName(lex *lexer, typ CmdType) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	return Cmd{typ, ArgsType{Identifier(tok.val)}}
+}
+
+// grant capability <cap> to p or revoke capability <cap> from p
+func parseCapability(lex *lexer, typ CmdType, prep tokenType) Cmd {
+	tok := lex.next()
+	if keyword(tok) != tokenCapability {
+		return invalidTokenError(tok.typ, tokenCapability)
+	}
+	var capability string
+	tok = lex.next()
+	if tok.typ == tokenId {
+		capability = tok.val
+	} else if tok.typ == tokenDelegator {
+		capability = "delegator"
+	} else {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	tok = lex.next()
+	if keyword(tok) != prep {
+		return invalidTokenError(tok.typ, prep)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	return Cmd{typ, ArgsType{Identifier(capability), Identifier(tok.val)}}
+}
+
+// <cmd> principal p
+func parsePrincipalCmd(lex *lexer, typ CmdType) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenPrincipal {
+		return invalidTokenError(tok.typ, tokenPrincipal)
+	}
+	return parsePrincipalName(lex, typ)
+}
+
+// parse principal name p for '<cmd> principal p' commands
+func parsePrincipalName(lex *lexer, typ CmdType) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	return Cmd{typ, ArgsType{Identifier(tok.val)}}
+}
+
+func parseDeleteDelegation(lex *lexer) Cmd {
 	args := parseDelegationArgs(lex)
 	if args == nil {
 		return Cmd{CmdError, ArgsType{"Failed to parse delegation args"}}
@@ -332,9 +863,20 @@ func parseDeleteDelegation(lex *lexer) Cmd {
 	return Cmd{CmdDeleteDelegation, args}
 }
 
+// set denial x q <right> -> p or delete denial x q <right> -> p
+func parseDenial(lex *lexer, typ CmdType) Cmd {
+	args := parseDelegationArgs(lex)
+	if args == nil {
+		return Cmd{CmdError, ArgsType{"Failed to parse denial args"}}
+	}
+	return Cmd{typ, args}
+}
+
 func parseDefaultDelegator(lex *lexer) Cmd {
 	tok := lex.next()
-	if tok.typ != tokenDelegator {
+	if keyword(tok) == tokenCreation {
+		return parseDefaultCreation(lex)
+	} else if tok.typ != tokenDelegator {
 		return invalidTokenError(tok.typ, tokenDelegator)
 	}
 	tok = lex.next()
@@ -350,27 +892,54 @@ func parseDefaultDelegator(lex *lexer) Cmd {
 	return cmd
 }
 
+// default creation = open|restricted ('default creation' already parsed)
+func parseDefaultCreation(lex *lexer) Cmd {
+	tok := lex.next()
+	if tok.typ != tokenEquals {
+		return invalidTokenError(tok.typ, tokenEquals)
+	}
+	tok = lex.next()
+	if tok.typ != tokenId {
+		return invalidTokenError(tok.typ, tokenId)
+	}
+	if tok.val != "open" && tok.val != "restricted" {
+		return errorCmd(fmt.Errorf("Unknown creation policy: %v", tok.val))
+	}
+	return Cmd{CmdDefaultCreation, ArgsType{Identifier(tok.val)}}
+}
+
 func parseExpr(lex *lexer) (interface{}, error) {
 	tok := lex.next()
-	switch tok.typ {
+	typ := tok.typ
+	if kw := keyword(tok); (kw == tokenMap || kw == tokenFilter) && lex.lookahead(2).typ == tokenIn {
+		typ = kw // map y in ... or filter y in ..., otherwise map and filter are variable names
+	}
+	switch typ {
 	case tokenStr:
 		return tok.val, nil
+	case tokenNumber:
+		return parseNumber(tok.val)
 	case tokenId:
-		tok2 := lex.next()
-		if (tok2.typ == tokenEnd) || (tok2.typ == tokenComment) { // x
-			return Identifier(tok.val), nil
-		} else if tok2.typ == tokenDot { // x.y
+		switch lex.peek().typ {
+		case tokenDot: // x.y
+			lex.next()
 			keyTok := lex.next()
 			if keyTok.typ != tokenId {
 				return nil, fmt.Errorf("Unexpected token '%v' for field value", keyTok.typ)
 			}
 			return FieldVal{tok.val, keyTok.val}, nil
-		} else if tok2.typ == tokenLeftParen { // function call
+		case tokenLeftParen: // function call
+			lex.next()
 			args, err := parseFunctionArgs(lex)
 			if err != nil {
 				return nil, err
 			}
 			return Function{tok.val, args}, nil
+		case tokenLeftSBracket: // x[i] or x[i:j]
+			lex.next()
+			return parseIndex(lex, tok.val)
+		default: // x
+			return Identifier(tok.val), nil
 		}
 	case tokenLeftSBracket: // []
 		tok2 := lex.next()
@@ -402,17 +971,71 @@ func parseExpr(lex *lexer) (interface{}, error) {
 			return nil, err
 		}
 		return Let{varTok.val, left, right}, nil
+	case tokenMap: // map y in <expr> => <expr>
+		varTok, list, err := parseIteration(lex, "map")
+		if err != nil {
+			return nil, err
+		}
+		arrowTok := lex.next()
+		if arrowTok.typ != tokenFatArrow {
+			return nil, fmt.Errorf("Unexpected token '%v' in 'map' expression", arrowTok.typ)
+		}
+		expr, err := parseExpr(lex)
+		if err != nil {
+			return nil, err
+		}
+		return Map{varTok.val, list, expr}, nil
+	case tokenFilter: // filter y in <expr> where <expr>
+		varTok, list, err := parseIteration(lex, "filter")
+		if err != nil {
+			return nil, err
+		}
+		whereTok := lex.next()
+		if keyword(whereTok) != tokenWhere {
+			return nil, fmt.Errorf("Unexpected token '%v' in 'filter' expression", whereTok.typ)
+		}
+		cond, err := parseExpr(lex)
+		if err != nil {
+			return nil, err
+		}
+		return Filter{varTok.val, list, cond}, nil
 	}
 	return nil, fmt.Errorf("Unexpected token '%v'", tok.typ)
 }
 
+// parse 'y in <expr>' part of map and filter expressions
+func parseIteration(lex *lexer, name string) (token, interface{}, error) {
+	varTok := lex.next()
+	if varTok.typ != tokenId {
+		return varTok, nil, fmt.Errorf("Unexpected token '%v' in '%s' expression", varTok.typ, name)
+	}
+	inTok := lex.next()
+	if inTok.typ != tokenIn {
+		return varTok, nil, fmt.Errorf("Unexpected token '%v' in '%s' expression", inTok.typ, name)
+	}
+	list, err := parseExpr(lex)
+	return varTok, list, err
+}
+
 // parse <tgt> q <right> -> p
 func parseDelegationArgs(lex *lexer) ArgsType {
 	args := make(ArgsType, 4)
 	tok := lex.next()
-	if tok.typ == tokenId {
+	typ := tok.typ
+	if next := lex.lookahead(3).typ; next != tokenArrow && next != tokenComma {
+		typ = keyword(tok) // namespace n q <right>, otherwise namespace is a variable name
+	}
+	if typ == tokenId {
 		args[0] = Identifier(tok.val)
-	} else if tok.typ == tokenAll {
+	} else if typ == tokenStr { // variable name pattern
+		args[0] = tok.val
+	} else if typ == tokenNamespace { // namespace n, grants are stored at n::
+		tok = lex.next()
+		if tok.typ != tokenId || strings.Contains(tok.val, "::") {
+			return nil
+		}
+		args[0] = Identifier(tok.val + "::")
+	} else if typ == tokenAll {
 		args[0] = Identifier("all")
 	} else {
 		return nil
@@ -422,18 +1045,8 @@ func parseDelegationArgs(lex *lexer) ArgsType {
 		return nil
 	}
 	args[1] = Identifier(tok.val)
-	tok = lex.next()
-	var right string
-	switch tok.typ {
-	case tokenRead:
-		right = "read"
-	case tokenWrite:
-		right = "write"
-	case tokenAppend:
-		right = "append"
-	case tokenDelegate:
-		right = "delegate"
-	default:
+	right := parseRights(lex)
+	if right == "" {
 		return nil
 	}
 	args[2] = Identifier(right)
@@ -449,6 +1062,47 @@ func parseDelegationArgs(lex *lexer) ArgsType {
 	return args
 }
 
+// all or comma separated list of rights, e.g. read,write
+// Returns "" if rights can not be parsed
+func parseRights(lex *lexer) string {
+	tok := lex.next()
+	if tok.typ == tokenAll {
+		return "all"
+	}
+	var rights []string
+	for {
+		right, ok := rightName(keyword(tok))
+		if !ok {
+			return ""
+		}
+		rights = append(rights, right)
+		if lex.peek().typ != tokenComma {
+			return strings.Join(rights, ",")
+		}
+		lex.next()
+		tok = lex.next()
+	}
+}
+
+// name of the right for right keyword tokens
+func rightName(typ tokenType) (string, bool) {
+	switch typ {
+	case tokenRead:
+		return "read", true
+	case tokenWrite:
+		return "write", true
+	case tokenAppend:
+		return "append", true
+	case tokenDelegate:
+		return "delegate", true
+	case tokenRemove:
+		return "remove", true
+	case tokenCreate:
+		return "create", true
+	}
+	return "", false
+}
+
 func parseRecord(lex *lexer) (Record, error) {
 	rec := make(Record)
 	for cur := lex.next(); cur.typ != tokenRightCBracket; {
@@ -496,6 +1150,13 @@ func parseFunctionArgs(lex *lexer) (ArgsType, error) {
 		case tokenStr:
 			args = append(args, cur.val)
 			cur = lex.next()
+		case tokenNumber:
+			n, err := parseNumber(cur.val)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, n)
+			cur = lex.next()
 		case tokenId:
 			val := cur.val
 			cur = lex.next()
@@ -505,9 +1166,34 @@ func parseFunctionArgs(lex *lexer) (ArgsType, error) {
 					args = append(args, FieldVal{val, cur.val}) // x.y
 					cur = lex.next()
 				}
+			} else if cur.typ == tokenLeftSBracket {
+				arg, err := parseIndex(lex, val) // x[i] or x[i:j]
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				cur = lex.next()
 			} else {
 				args = append(args, Identifier(val)) // v
 			}
+		case tokenLeftSBracket: // []
+			cur = lex.next()
+			if cur.typ != tokenRightSBracket {
+				return nil, fmt.Errorf("Unexpected token '%v' for list type", cur.typ)
+			}
+			args = append(args, List{})
+			cur = lex.next()
+		case tokenLeftCBracket: // {a = "s", b = v}
+			rec, err := parseRecord(lex)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, rec)
+			cur = lex.next()
+		case tokenRead, tokenWrite, tokenAppend, tokenDelegate, tokenCreate: // right names
+			right, _ := rightName(cur.typ)
+			args = append(args, Identifier(right))
+			cur = lex.next()
 		default:
 			return nil, fmt.Errorf("Unexpected token '%v' for function argument", cur.typ)
 		}
@@ -518,6 +1204,48 @@ func parseFunctionArgs(lex *lexer) (ArgsType, error) {
 	return args, nil
 }
 
+// parse index x[i] or slice x[i:j] where i and j are optional for slice
+// (x and '[' already parsed)
+func parseIndex(lex *lexer, x string) (interface{}, error) {
+	var low, high *Number
+	tok := lex.next()
+	if tok.typ == tokenNumber {
+		n, err := parseNumber(tok.val)
+		if err != nil {
+			return nil, err
+		}
+		low = &n
+		tok = lex.next()
+	}
+	if tok.typ == tokenRightSBracket && low != nil {
+		return Index{x, *low}, nil
+	}
+	if tok.typ != tokenColon {
+		return nil, fmt.Errorf("Unexpected token '%v' for list index", tok.typ)
+	}
+	tok = lex.next()
+	if tok.typ == tokenNumber {
+		n, err := parseNumber(tok.val)
+		if err != nil {
+			return nil, err
+		}
+		high = &n
+		tok = lex.next()
+	}
+	if tok.typ != tokenRightSBracket {
+		return nil, fmt.Errorf("Unexpected token '%v' for list slice", tok.typ)
+	}
+	return Slice{x, low, high}, nil
+}
+
+func parseNumber(val string) (Number, error) {
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid number '%s'", val)
+	}
+	return Number(n), nil
+}
+
 func errorCmd(err error) Cmd {
 	return Cmd{CmdError, ArgsType{err}}
 }
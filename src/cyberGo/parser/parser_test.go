@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestParse(t *testing.T) {
@@ -65,6 +66,82 @@ func TestParse(t *testing.T) {
 			CmdForeach,
 			ArgsType{Identifier("y"), Identifier("x"), Identifier("z")},
 		}},
+		{"foreach into", `foreach y in x replacewith concat(y, "!") into z`, Cmd{
+			CmdForeach,
+			ArgsType{Identifier("y"), Identifier("x"), Function{"concat", ArgsType{Identifier("y"), "!"}}, Identifier("z")},
+		}},
+		{"into as variable name", `foreach y in into replacewith y into into`, Cmd{
+			CmdForeach,
+			ArgsType{Identifier("y"), Identifier("into"), Identifier("y"), Identifier("into")},
+		}},
+		{"filtereach into", `filtereach y in x with equal(y, "a") into z`, Cmd{
+			CmdFiltereach,
+			ArgsType{Identifier("y"), Identifier("x"), Function{"equal", ArgsType{Identifier("y"), "a"}}, Identifier("z")},
+		}},
+		{"parse should fail for foreach into string", `foreach y in x replacewith y into "z"`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Invalid token error (expected=id, got=str)")},
+		}},
+		{"index", `return x[-1]`, Cmd{
+			CmdReturn,
+			ArgsType{Index{"x", -1}},
+		}},
+		{"slice", `return x[1:3]`, Cmd{
+			CmdReturn,
+			ArgsType{Slice{"x", numberPtr(1), numberPtr(3)}},
+		}},
+		{"open slice", `return concat(x[:2], y[1:])`, Cmd{
+			CmdReturn,
+			ArgsType{Function{"concat", ArgsType{Slice{"x", nil, numberPtr(2)}, Slice{"y", numberPtr(1), nil}}}},
+		}},
+		{"right as function argument", `return explain(x, p, delegate)`, Cmd{
+			CmdReturn,
+			ArgsType{Function{"explain", ArgsType{Identifier("x"), Identifier("p"), Identifier("delegate")}}},
+		}},
+		{"set index", `set x[0] = "a"`, Cmd{
+			CmdSetIndex,
+			ArgsType{Identifier("x"), Number(0), "a"},
+		}},
+		{"parse should fail for empty index", `return x[]`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Unexpected token 'rightSBracket' for list index")},
+		}},
+		{"parse should fail for identifier index", `set x[y] = "a"`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Invalid token error (expected=number, got=id)")},
+		}},
+		{"remove from", `remove from x where y => equal(y.f, "a")`, Cmd{
+			CmdRemoveFrom,
+			ArgsType{Identifier("x"), Identifier("y"), Function{"equal", ArgsType{FieldVal{"y", "f"}, "a"}}},
+		}},
+		{"remove from variable named from", `remove from from where y => equal(y, pop)`, Cmd{
+			CmdRemoveFrom,
+			ArgsType{Identifier("from"), Identifier("y"), Function{"equal", ArgsType{Identifier("y"), Identifier("pop")}}},
+		}},
+		{"list commands on variables named as keywords", `prepend to prepend with remove`, Cmd{
+			CmdPrependTo,
+			ArgsType{Identifier("prepend"), Identifier("remove")},
+		}},
+		{"pop variable named pop", `pop pop`, Cmd{
+			CmdPop,
+			ArgsType{Identifier("pop")},
+		}},
+		{"remove principal named from", `remove from from group g`, Cmd{
+			CmdRemoveFromGroup,
+			ArgsType{Identifier("from"), Identifier("g")},
+		}},
+		{"pop", `pop x`, Cmd{
+			CmdPop,
+			ArgsType{Identifier("x")},
+		}},
+		{"prepend to", `prepend to x with {f = "a"}`, Cmd{
+			CmdPrependTo,
+			ArgsType{Identifier("x"), Record{"f": "a"}},
+		}},
+		{"set delegation remove", `set delegation x q remove -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("remove"), Identifier("p")},
+		}},
 		{"set delegation x", `set delegation x q read -> p`, Cmd{
 			CmdSetDelegation,
 			ArgsType{Identifier("x"), Identifier("q"), Identifier("read"), Identifier("p")},
@@ -81,6 +158,239 @@ func TestParse(t *testing.T) {
 			CmdDeleteDelegation,
 			ArgsType{Identifier("all"), Identifier("q"), Identifier("read"), Identifier("p")},
 		}},
+		{"delete variable", `delete x`, Cmd{
+			CmdDeleteVar,
+			ArgsType{Identifier("x")},
+		}},
+		{"disable and enable as variable names", `set disable = enable`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("disable"), Identifier("enable")},
+		}},
+		{"disable principal named enable", `disable principal enable`, Cmd{
+			CmdDisablePrincipal,
+			ArgsType{Identifier("enable")},
+		}},
+		{"copy as variable name", `set copy = rename`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("copy"), Identifier("rename")},
+		}},
+		{"copy variables named as keywords", `copy copy to delegations with delegations`, Cmd{
+			CmdCopyDelegations,
+			ArgsType{Identifier("copy"), Identifier("delegations")},
+		}},
+		{"rename variable named rename", `rename rename to copy`, Cmd{
+			CmdRenameVar,
+			ArgsType{Identifier("rename"), Identifier("copy")},
+		}},
+		{"for and until as variable names", `set for = until`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("for"), Identifier("until")},
+		}},
+		{"set delegation on variable named until", `set delegation until q read -> for for "1h"`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("until"), Identifier("q"), Identifier("read"), Identifier("for"), Expiry{For: time.Hour}},
+		}},
+		{"set variable named denial", `set denial = "x"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("denial"), "x"},
+		}},
+		{"set field of variable named denial", `set denial.f = "x"`, Cmd{
+			CmdSetField,
+			ArgsType{Identifier("denial"), Identifier("f"), "x"},
+		}},
+		{"delete variable named denial", `delete denial`, Cmd{
+			CmdDeleteVar,
+			ArgsType{Identifier("denial")},
+		}},
+		{"delete denial on variable named denial", `delete denial denial q read -> p`, Cmd{
+			CmdDeleteDenial,
+			ArgsType{Identifier("denial"), Identifier("q"), Identifier("read"), Identifier("p")},
+		}},
+		{"set delegation on variable named namespace", `set delegation namespace q remove -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("namespace"), Identifier("q"), Identifier("remove"), Identifier("p")},
+		}},
+		{"set delegation on namespace named namespace", `set delegation namespace namespace q remove -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("namespace::"), Identifier("q"), Identifier("remove"), Identifier("p")},
+		}},
+		{"create namespace named namespace", `create namespace namespace`, Cmd{
+			CmdCreateNamespace,
+			ArgsType{Identifier("namespace")},
+		}},
+		{"set variable named namespace", `set namespace = "x"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("namespace"), "x"},
+		}},
+		{"set variable named creation", `set creation = "x"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("creation"), "x"},
+		}},
+		{"rename", `rename x to y`, Cmd{
+			CmdRenameVar,
+			ArgsType{Identifier("x"), Identifier("y")},
+		}},
+		{"copy", `copy x to y`, Cmd{
+			CmdCopyVar,
+			ArgsType{Identifier("x"), Identifier("y")},
+		}},
+		{"copy with delegations", `copy x to y with delegations`, Cmd{
+			CmdCopyDelegations,
+			ArgsType{Identifier("x"), Identifier("y")},
+		}},
+		{"export", `export delegations dot`, Cmd{
+			CmdExport,
+			ArgsType{Identifier("dot"), nil, nil},
+		}},
+		{"export filters named as keywords", `export delegations dot principal on on export`, Cmd{
+			CmdExport,
+			ArgsType{Identifier("dot"), Identifier("on"), Identifier("export")},
+		}},
+		{"export and on as variable names", `set export = on`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("export"), Identifier("on")},
+		}},
+		{"export with filters", `export delegations json principal p on x`, Cmd{
+			CmdExport,
+			ArgsType{Identifier("json"), Identifier("p"), Identifier("x")},
+		}},
+		{"create group", `create group g`, Cmd{
+			CmdCreateGroup,
+			ArgsType{Identifier("g")},
+		}},
+		{"add to group", `add p to group g`, Cmd{
+			CmdAddToGroup,
+			ArgsType{Identifier("p"), Identifier("g")},
+		}},
+		{"group as variable name", `set group = "x"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("group"), "x"},
+		}},
+		{"add to group named group", `add group to group group`, Cmd{
+			CmdAddToGroup,
+			ArgsType{Identifier("group"), Identifier("group")},
+		}},
+		{"create group named group", `create group group`, Cmd{
+			CmdCreateGroup,
+			ArgsType{Identifier("group")},
+		}},
+		{"remove from group", `remove p from group g`, Cmd{
+			CmdRemoveFromGroup,
+			ArgsType{Identifier("p"), Identifier("g")},
+		}},
+		{"parse should fail for add without group", `add p to g`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Invalid token error (expected=group, got=id)")},
+		}},
+		{"set delegation for", `set delegation x q read -> p for "24h"`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("read"), Identifier("p"), Expiry{For: 24 * time.Hour}},
+		}},
+		{"set delegation until", `set delegation x q read -> p until "2030-01-02T15:04:05Z"`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("read"), Identifier("p"),
+				Expiry{Until: time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)}},
+		}},
+		{"parse should fail for negative delegation duration", `set delegation x q read -> p for "-1h"`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Delegation duration must be positive: -1h")},
+		}},
+		{"set delegation right list", `set delegation x q read,write -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("read,write"), Identifier("p")},
+		}},
+		{"delete delegation all rights", `delete delegation all q all -> p`, Cmd{
+			CmdDeleteDelegation,
+			ArgsType{Identifier("all"), Identifier("q"), Identifier("all"), Identifier("p")},
+		}},
+		{"parse should fail for trailing comma in right list", `set delegation x q read, -> p`, Cmd{
+			CmdError,
+			ArgsType{"Failed to parse delegation args"},
+		}},
+		{"set delegation pattern", `set delegation "logs_*" q read -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{"logs_*", Identifier("q"), Identifier("read"), Identifier("p")},
+		}},
+		{"create namespace", `create namespace team`, Cmd{
+			CmdCreateNamespace,
+			ArgsType{Identifier("team")},
+		}},
+		{"parse should fail for nested namespace", `create namespace a::b`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Nested namespace: a::b")},
+		}},
+		{"set delegation namespace", `set delegation namespace team q read -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("team::"), Identifier("q"), Identifier("read"), Identifier("p")},
+		}},
+		{"set namespaced variable", `set team::x = "a"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("team::x"), "a"},
+		}},
+		{"set delegation create", `set delegation all admin create -> p`, Cmd{
+			CmdSetDelegation,
+			ArgsType{Identifier("all"), Identifier("admin"), Identifier("create"), Identifier("p")},
+		}},
+		{"default creation", `default creation = restricted`, Cmd{
+			CmdDefaultCreation,
+			ArgsType{Identifier("restricted")},
+		}},
+		{"parse should fail for unknown creation policy", `default creation = closed`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Unknown creation policy: closed")},
+		}},
+		{"set quota", `set quota p bytes = 1024`, Cmd{
+			CmdSetQuota,
+			ArgsType{Identifier("p"), Identifier("bytes"), Number(1024)},
+		}},
+		{"set variable named quota", `set quota = "x"`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("quota"), "x"},
+		}},
+		{"set quota of principal named quota", `set quota quota length = 3`, Cmd{
+			CmdSetQuota,
+			ArgsType{Identifier("quota"), Identifier("length"), Number(3)},
+		}},
+		{"set negative quota", `set quota p bytes = -1`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Quota must not be negative: -1")},
+		}},
+		{"grant and revoke as variable names", `set grant = revoke`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("grant"), Identifier("revoke")},
+		}},
+		{"grant capability to principal named capability", `grant capability groups to capability`, Cmd{
+			CmdGrantCapability,
+			ArgsType{Identifier("groups"), Identifier("capability")},
+		}},
+		{"grant capability", `grant capability delegator to p`, Cmd{
+			CmdGrantCapability,
+			ArgsType{Identifier("delegator"), Identifier("p")},
+		}},
+		{"revoke capability", `revoke capability passwords from p`, Cmd{
+			CmdRevokeCapability,
+			ArgsType{Identifier("passwords"), Identifier("p")},
+		}},
+		{"set denial", `set denial x q read -> p`, Cmd{
+			CmdSetDenial,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("read"), Identifier("p")},
+		}},
+		{"delete denial", `delete denial x q write -> p`, Cmd{
+			CmdDeleteDenial,
+			ArgsType{Identifier("x"), Identifier("q"), Identifier("write"), Identifier("p")},
+		}},
+		{"delete principal", `delete principal p`, Cmd{
+			CmdDeletePrincipal,
+			ArgsType{Identifier("p")},
+		}},
+		{"disable principal", `disable principal p`, Cmd{
+			CmdDisablePrincipal,
+			ArgsType{Identifier("p")},
+		}},
+		{"enable principal", `enable principal p`, Cmd{
+			CmdEnablePrincipal,
+			ArgsType{Identifier("p")},
+		}},
 		{"default delegator = x", `default delegator = x`, Cmd{
 			CmdDefaultDelegator,
 			ArgsType{Identifier("x")},
@@ -133,6 +443,34 @@ func TestParse(t *testing.T) {
 			CmdFiltereach,
 			ArgsType{Identifier("rec"), Identifier("records"), Function{"equal", ArgsType{FieldVal{"rec", "date"}, "1-1-90"}}},
 		}},
+		{"set number", `set x = -42`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("x"), Number(-42)},
+		}},
+		{"arithmetic function", `set x = add(y,10)`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("x"), Function{"add", ArgsType{Identifier("y"), Number(10)}}},
+		}},
+		{"record function", `set x = setfield({a = "b"}, "c", y.d)`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("x"), Function{"setfield", ArgsType{Record{"a": "b"}, "c", FieldVal{"y", "d"}}}},
+		}},
+		{"empty list function argument", `set x = len([])`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("x"), Function{"len", ArgsType{List{}}}},
+		}},
+		{"set field", `set x.f = "abc"`, Cmd{
+			CmdSetField,
+			ArgsType{Identifier("x"), Identifier("f"), "abc"},
+		}},
+		{"append to field", `append to x.f with y.g`, Cmd{
+			CmdAppendToField,
+			ArgsType{Identifier("x"), Identifier("f"), FieldVal{"y", "g"}},
+		}},
+		{"parse should fail for incomplete field assignment", `set x. = "abc"`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Invalid token error (expected=id, got=equals)")},
+		}},
 		{"simple let", `set y = let z = concat(x.f1, " ") in concat(z, x.f2)`, Cmd{
 			CmdSet,
 			ArgsType{Identifier("y"), Let{
@@ -161,6 +499,66 @@ func TestParse(t *testing.T) {
 				},
 			}},
 		}},
+		{"let with identifier", `return let z = x in concat(z, "a")`, Cmd{
+			CmdReturn,
+			ArgsType{Let{
+				Var:   "z",
+				Left:  Identifier("x"),
+				Right: Function{"concat", ArgsType{Identifier("z"), "a"}},
+			}},
+		}},
+		{"map", `set z = map y in x => concat(y, "!")`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("z"), Map{
+				Var:  "y",
+				List: Identifier("x"),
+				Expr: Function{"concat", ArgsType{Identifier("y"), "!"}},
+			}},
+		}},
+		{"filter", `return filter y in splitby(x.f, ",") where notequal(y, "")`, Cmd{
+			CmdReturn,
+			ArgsType{Filter{
+				Var:  "y",
+				List: Function{"splitby", ArgsType{FieldVal{"x", "f"}, ","}},
+				Cond: Function{"notequal", ArgsType{Identifier("y"), ""}},
+			}},
+		}},
+		{"map in let", `return let l = map y in x => y.f in filter z in l where equal(z, "a")`, Cmd{
+			CmdReturn,
+			ArgsType{Let{
+				Var:  "l",
+				Left: Map{Var: "y", List: Identifier("x"), Expr: FieldVal{"y", "f"}},
+				Right: Filter{
+					Var:  "z",
+					List: Identifier("l"),
+					Cond: Function{"equal", ArgsType{Identifier("z"), "a"}},
+				},
+			}},
+		}},
+		{"map as variable name", `set map = filter`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("map"), Identifier("filter")},
+		}},
+		{"map of variables named as keywords", `set where = map map in filter => where`, Cmd{
+			CmdSet,
+			ArgsType{Identifier("where"), Map{Var: "map", List: Identifier("filter"), Expr: Identifier("where")}},
+		}},
+		{"filter where variable", `return filter y in where where equal(y, where.f)`, Cmd{
+			CmdReturn,
+			ArgsType{Filter{
+				Var:  "y",
+				List: Identifier("where"),
+				Cond: Function{"equal", ArgsType{Identifier("y"), FieldVal{"where", "f"}}},
+			}},
+		}},
+		{"parse should fail for incomplete map", `return map y in x`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Unexpected token 'end' in 'map' expression")},
+		}},
+		{"parse should fail for tokens after identifier", `return x y`, Cmd{
+			CmdError,
+			ArgsType{fmt.Errorf("Invalid token error (expected=end, got=id)")},
+		}},
 	}
 	for _, c := range cases {
 		cmd := Parse(c.in)
@@ -179,6 +577,10 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func numberPtr(n Number) *Number {
+	return &n
+}
+
 func TestString(t *testing.T) {
 	var id interface{} = "test"
 	switch id.(type) {
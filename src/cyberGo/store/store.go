@@ -2,18 +2,30 @@ package store
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"math/rand"
+	"sort"
+	"strings"
 	"time"
 )
 
 var ErrFailed = errors.New("store: failed")
 var ErrDenied = errors.New("store: denied")
+var ErrQuota = errors.New("store: quota exceeded") // failure because of exceeded principal quota
 
 const adminUsername = "admin"
 const anyoneUsername = "anyone"
 const allVars = "all"
+const MaxStringLength = 65535 // longer string values are truncated
+const maxExplainPaths = 100
+const maxExplainNodes = 10000 // principals visited by explain before giving up on more paths
+const maxPattern = 255
+const namespaceSep = "::"
+const rootNamespace = namespaceSep // key of create right delegations for not namespaced variables
 
 type ListVal []interface{}
+type NumberVal int64
 type RecordVal map[string]string // Record fields may only contain strings, not nested records
 
 /// Permission type for store permissions
@@ -28,12 +40,33 @@ const (
 	PermissionDelegate
 	// PermissionAppend for append
 	PermissionAppend
+	// PermissionRemove for remove
+	PermissionRemove
+	// PermissionCreate for creation of variables, delegated on namespaces only
+	PermissionCreate
+	// PermissionAll for all variable rights, used in delegation commands only
+	PermissionAll = PermissionRead | PermissionWrite | PermissionDelegate | PermissionAppend | PermissionRemove
 )
 
 func (p Permission) IsSet(flag Permission) bool { return p&flag != 0 }
 func (p *Permission) Set(flag Permission)       { *p |= flag }
 func (p *Permission) Clear(flag Permission)     { *p &= ^flag }
 
+//string representation of Capability enum, name used in commands
+func (c Capability) String() string {
+	switch c {
+	case CapabilityPrincipals:
+		return "principals"
+	case CapabilityPasswords:
+		return "passwords"
+	case CapabilityGroups:
+		return "groups"
+	case CapabilityDelegator:
+		return "delegator"
+	}
+	return ""
+}
+
 //string representation of Permission enum
 func (p Permission) String() string {
 	switch p {
@@ -45,12 +78,90 @@ func (p Permission) String() string {
 		return "PermissionDelegate"
 	case PermissionAppend:
 		return "PermissionAppend"
+	case PermissionRemove:
+		return "PermissionRemove"
+	case PermissionCreate:
+		return "PermissionCreate"
 	}
 	return ""
 }
 
+/// Capability type for administrative capabilities granted by admin
+type Capability uint
+
+const (
+	// CapabilityPrincipals for create principal
+	CapabilityPrincipals Capability = 1 << iota
+	// CapabilityPasswords for change password of other principals
+	CapabilityPasswords
+	// CapabilityGroups for create group, add to group and remove from group
+	CapabilityGroups
+	// CapabilityDelegator for default delegator
+	CapabilityDelegator
+)
+
+// QuotaKind type for limits on variables owned by a principal
+type QuotaKind int
+
+const (
+	// QuotaVariables limits number of global variables created by the principal
+	QuotaVariables QuotaKind = iota
+	// QuotaBytes limits total size of values of variables created by the principal
+	QuotaBytes
+	// QuotaLength limits length of (flattened) lists stored in variables created by the principal
+	QuotaLength
+)
+
+func (k QuotaKind) String() string {
+	switch k {
+	case QuotaVariables:
+		return "variables"
+	case QuotaBytes:
+		return "bytes"
+	case QuotaLength:
+		return "length"
+	}
+	return "unknown"
+}
+
+// Quotas of a principal by kind, 0 means unlimited
+type Quotas map[QuotaKind]int64
+
+// all rights in the order of the command description
+var allPermissions = []Permission{PermissionRead, PermissionWrite, PermissionAppend, PermissionDelegate,
+	PermissionRemove}
+
+// Split set of rights to single rights in the order of allPermissions, create right is the last
+func (p Permission) Split() []Permission {
+	var res []Permission
+	for _, perm := range append(allPermissions, PermissionCreate) {
+		if p.IsSet(perm) {
+			res = append(res, perm)
+		}
+	}
+	return res
+}
+
+// right name of the permission as used in commands, e.g. read
+func permName(p Permission) string {
+	return strings.ToLower(strings.TrimPrefix(p.String(), "Permission"))
+}
+
 // Assertion to hold all delegation for targetUser(key) PermissionsState
-type PermRecords map[string]map[Permission]map[string]bool
+// Owner is mapped to expiration time of the delegation, zero time means no expiration
+type PermRecords map[string]map[Permission]map[string]time.Time
+
+// Delegation assertion, the owner delegates the right on the variable to the target
+type DelegationEdge struct {
+	Var    string `json:"var"`
+	Owner  string `json:"owner"`
+	Target string `json:"target"`
+	Right  string `json:"right"`
+	// RFC3339 expiration time, empty if delegation does not expire
+	Expires string `json:"expires,omitempty"`
+	// Var is namespace n:: of a namespace delegation, or :: for the create right outside namespaces
+	Namespace bool `json:"namespace,omitempty"`
+}
 
 type PermCacheKey struct {
 	username string
@@ -66,22 +177,50 @@ type PermVisitedKey struct {
 
 // Global store
 type Store struct {
-	users            map[string]string // username is key
+	users            map[string]string          // username is key
+	disabled         map[string]bool            // disabled usernames
+	groups           map[string]map[string]bool // group name is key, members are principals or groups
 	vars             map[string]interface{}
 	assertions       map[string]PermRecords //key is varname
+	denials          map[string]PermRecords //key is varname, negative assertions
+	patterns         map[string]PermRecords //key is variable name pattern
+	namespaces       map[string]bool        //namespace grants are stored in assertions with key n::
+	restrictCreation bool                   //variable creation requires create right
+	capabilities     map[string]Capability  //administrative capabilities of principals
+	owners           map[string]string      //key is varname, value is principal who created it
+	quotas           map[string]Quotas      //quotas set by admin per principal
+	defaultQuotas    Quotas                 //quotas of principals without own quota of the kind
+	size             int64                  //total size of global variables, see varSize
+	maxSize          int64                  //limit of size, 0 means unlimited
 	defaultDelegator string
+	now              func() time.Time // clock for delegation expiration
 }
 
 // Defered storage per connection
 type LocalStore struct {
 	global            *Store
 	users             map[string]string
+	deletedUsers      map[string]bool // global users to be deleted on commit
+	disabled          map[string]bool
+	groups            map[string]map[string]bool
 	vars              map[string]interface{}
 	locals            map[string]interface{}
+	deletedVars       map[string]bool // global variables to be deleted on commit
+	sizeDelta         int64           // change of the global store size on commit
+	localsSize        int64           // size of local variables
 	currUserName      string
 	assertions        map[string]PermRecords //key is varname
+	denials           map[string]PermRecords //key is varname
+	patterns          map[string]PermRecords //key is variable name pattern
+	namespaces        map[string]bool
+	restrictCreation  bool
+	capabilities      map[string]Capability
+	owners            map[string]string
+	quotas            map[string]Quotas
 	permissionCache   map[PermCacheKey]bool
 	visitedAssertions map[PermVisitedKey]bool
+	denialChecks      map[PermCacheKey]bool // denial checks in progress, breaks cycles of denials between delegators
+	auditLog          []string              // capability audit records, logged on commit
 	defaultDelegator  string
 	bIsAdmin          bool
 }
@@ -89,9 +228,19 @@ type LocalStore struct {
 func NewStore(adminPassword string) *Store {
 	return &Store{
 		users:            map[string]string{adminUsername: adminPassword, anyoneUsername: randPass()},
+		disabled:         make(map[string]bool),
+		groups:           make(map[string]map[string]bool),
 		vars:             make(map[string]interface{}, 100),
-		assertions:       make(map[string]PermRecords, 100),
+		assertions:       map[string]PermRecords{rootNamespace: {}},
+		denials:          make(map[string]PermRecords),
+		patterns:         make(map[string]PermRecords),
+		namespaces:       make(map[string]bool),
+		capabilities:     make(map[string]Capability),
+		owners:           make(map[string]string, 100),
+		quotas:           make(map[string]Quotas),
+		defaultQuotas:    make(Quotas),
 		defaultDelegator: anyoneUsername,
+		now:              time.Now,
 	}
 }
 
@@ -101,19 +250,35 @@ func (s *Store) AsPrincipal(username, password string) (*LocalStore, error) {
 	if !exists {
 		return nil, ErrFailed
 	}
-	if pwd != password {
+	if pwd != password || s.disabled[username] {
 		return nil, ErrDenied
 	}
+	disabled := make(map[string]bool, len(s.disabled))
+	for u := range s.disabled {
+		disabled[u] = true
+	}
 	return &LocalStore{
 		global:            s,
 		currUserName:      username,
 		bIsAdmin:          username == adminUsername,
 		users:             make(map[string]string),
+		deletedUsers:      make(map[string]bool),
+		disabled:          disabled,
+		groups:            s.copyGroupsFromGlobal(),
 		vars:              make(map[string]interface{}),
 		locals:            make(map[string]interface{}),
+		deletedVars:       make(map[string]bool),
 		assertions:        s.copyAssertionsFromGlobal(),
+		denials:           copyPermTable(s.denials),
+		patterns:          copyPermTable(s.patterns),
+		namespaces:        s.copyNamespacesFromGlobal(),
+		restrictCreation:  s.restrictCreation,
+		capabilities:      s.copyCapabilitiesFromGlobal(),
+		owners:            s.copyOwnersFromGlobal(),
+		quotas:            s.copyQuotasFromGlobal(),
 		permissionCache:   make(map[PermCacheKey]bool),
 		visitedAssertions: make(map[PermVisitedKey]bool),
+		denialChecks:      make(map[PermCacheKey]bool),
 		defaultDelegator:  s.defaultDelegator,
 	}, nil
 }
@@ -123,40 +288,131 @@ func (ls *LocalStore) IsAdmin() bool {
 	// return ls.currUserName == adminUsername
 }
 
-// type PermRecords map[string]map[Permission]map[string]bool
+// type PermRecords map[string]map[Permission]map[string]time.Time
 func (s *Store) copyAssertionsFromGlobal() map[string]PermRecords {
-	ls := make(map[string]PermRecords, len(s.assertions))
-	for varname, permRec := range s.assertions {
-		if _, ok := ls[varname]; !ok {
-			ls[varname] = make(PermRecords, len(permRec))
-		}
-		for targetUser, pPermRec := range permRec {
-			if _, ok := ls[varname][targetUser]; !ok {
-				ls[varname][targetUser] = make(map[Permission]map[string]bool)
-			}
-			for perm, pOwnerRec := range pPermRec {
-				if _, ok := ls[varname][targetUser][perm]; !ok {
-					ls[varname][targetUser][perm] = make(map[string]bool)
-				}
-				for owner, v := range pOwnerRec {
-					ls[varname][targetUser][perm][owner] = v
-				}
+	return copyPermTable(s.assertions)
+}
+
+func copyPermTable(table map[string]PermRecords) map[string]PermRecords {
+	res := make(map[string]PermRecords, len(table))
+	for varname, permRec := range table {
+		res[varname] = copyPermRecords(permRec)
+	}
+	return res
+}
+
+func copyPermRecords(permRec PermRecords) PermRecords {
+	res := make(PermRecords, len(permRec))
+	for targetUser, pPermRec := range permRec {
+		res[targetUser] = make(map[Permission]map[string]time.Time, len(pPermRec))
+		for perm, pOwnerRec := range pPermRec {
+			res[targetUser][perm] = make(map[string]time.Time, len(pOwnerRec))
+			for owner, v := range pOwnerRec {
+				res[targetUser][perm][owner] = v
 			}
 		}
 	}
-	return ls
+	return res
+}
+
+func (s *Store) copyGroupsFromGlobal() map[string]map[string]bool {
+	groups := make(map[string]map[string]bool, len(s.groups))
+	for g, members := range s.groups {
+		groups[g] = make(map[string]bool, len(members))
+		for m := range members {
+			groups[g][m] = true
+		}
+	}
+	return groups
+}
+
+func (s *Store) copyNamespacesFromGlobal() map[string]bool {
+	namespaces := make(map[string]bool, len(s.namespaces))
+	for n := range s.namespaces {
+		namespaces[n] = true
+	}
+	return namespaces
+}
+
+func (s *Store) copyCapabilitiesFromGlobal() map[string]Capability {
+	capabilities := make(map[string]Capability, len(s.capabilities))
+	for u, c := range s.capabilities {
+		capabilities[u] = c
+	}
+	return capabilities
+}
+
+func (s *Store) copyOwnersFromGlobal() map[string]string {
+	owners := make(map[string]string, len(s.owners))
+	for n, u := range s.owners {
+		owners[n] = u
+	}
+	return owners
+}
+
+func (s *Store) copyQuotasFromGlobal() map[string]Quotas {
+	quotas := make(map[string]Quotas, len(s.quotas))
+	for u, q := range s.quotas {
+		quotas[u] = make(Quotas, len(q))
+		for k, n := range q {
+			quotas[u][k] = n
+		}
+	}
+	return quotas
+}
+
+// Set default quota of the kind for principals without own quota, 0 means unlimited
+func (s *Store) SetDefaultQuota(kind QuotaKind, n int64) {
+	s.defaultQuotas[kind] = n
+}
+
+// Set limit of the total size of variables, 0 means unlimited
+func (s *Store) SetMaxSize(n int64) {
+	s.maxSize = n
+}
+
+// Set clock used for delegation expiration, time.Now by default
+func (s *Store) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// Current time of the store clock
+func (ls *LocalStore) Now() time.Time {
+	return ls.global.now()
 }
 
 // Commit changes to global store
 func (ls *LocalStore) Commit() {
+	for u := range ls.deletedUsers {
+		delete(ls.global.users, u)
+	}
 	for u, p := range ls.users {
 		ls.global.users[u] = p
 	}
+	for n := range ls.deletedVars {
+		delete(ls.global.vars, n)
+	}
 	for n, v := range ls.vars {
 		ls.global.vars[n] = v
 	}
+	ls.global.size += ls.sizeDelta
+	ls.sizeDelta = 0
+	ls.global.disabled = ls.disabled
+	ls.global.groups = ls.groups
+	ls.deleteExpiredAssertions()
 	ls.global.assertions = ls.assertions
+	ls.global.denials = ls.denials
+	ls.global.patterns = ls.patterns
+	ls.global.namespaces = ls.namespaces
+	ls.global.restrictCreation = ls.restrictCreation
+	ls.global.capabilities = ls.capabilities
+	ls.global.owners = ls.owners
+	ls.global.quotas = ls.quotas
 	ls.global.defaultDelegator = ls.defaultDelegator
+	for _, record := range ls.auditLog {
+		log.Print("audit: " + record)
+	}
+	ls.auditLog = nil
 }
 
 // create principal p s
@@ -167,14 +423,14 @@ func (ls *LocalStore) Commit() {
 // below, for more about this command, and see the permissions discussion for more on how principal anyone is used.)
 // Failure conditions:
 // Fails if p already exists as a principal.
-// Security violation if the current principal is not admin.
+// Security violation if the current principal is not admin and does not have principals capability.
 // Successful status code: CREATE_PRINCIPAL
 func (ls *LocalStore) CreatePrincipal(username string, password string) error {
-	if ls.userExists(username) {
+	if ls.userExists(username) || ls.isGroup(username) {
 		return ErrFailed
 	}
 
-	if !ls.IsAdmin() {
+	if !ls.hasCapability(CapabilityPrincipals, "create principal "+username) {
 		return ErrDenied
 	}
 
@@ -183,26 +439,329 @@ func (ls *LocalStore) CreatePrincipal(username string, password string) error {
 	// This means that when a principal q is created,
 	// the system automatically delegates all from p to q. Changing the default delegator does not
 	// affect the permissions of existing principals. The initial default delegator is anyone.
+	// Applied directly, the caller may hold the principals capability only and not be the default delegator.
 	if ls.getDefaultDelegator() != anyoneUsername {
-		ls.SetDelegation(allVars, ls.getDefaultDelegator(), PermissionRead, username)
-		ls.SetDelegation(allVars, ls.getDefaultDelegator(), PermissionWrite, username)
-		ls.SetDelegation(allVars, ls.getDefaultDelegator(), PermissionDelegate, username)
-		ls.SetDelegation(allVars, ls.getDefaultDelegator(), PermissionAppend, username)
+		ls.addAllAssertions(ls.getDefaultDelegator(), PermissionAll, username, time.Time{})
+	}
+	return nil
+}
+
+// delete principal p
+// Deletes the principal p together with all delegation assertions where p is the delegator or the delegatee.
+// If p is the default delegator, the default delegator is reset to anyone.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone.
+// Security violation if the current principal is not admin.
+// Successful status code: DELETE_PRINCIPAL
+func (ls *LocalStore) DeletePrincipal(username string) error {
+	if !ls.userExists(username) || isReservedUser(username) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	delete(ls.users, username)
+	if _, ok := ls.global.users[username]; ok {
+		ls.deletedUsers[username] = true
+	}
+	delete(ls.disabled, username)
+	delete(ls.capabilities, username)
+	delete(ls.quotas, username)
+	for n, owner := range ls.owners {
+		if owner == username {
+			delete(ls.owners, n)
+		}
+	}
+	for _, members := range ls.groups {
+		delete(members, username)
+	}
+	deletePrincipalRecords(ls.assertions, username)
+	deletePrincipalRecords(ls.denials, username)
+	deletePrincipalRecords(ls.patterns, username)
+	if ls.getDefaultDelegator() == username {
+		ls.defaultDelegator = anyoneUsername
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// disable principal p
+// Disables the principal p, so it can not be used in 'as principal' any more.
+// Delegations of p are not changed.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone.
+// Security violation if the current principal is not admin.
+// Successful status code: DISABLE_PRINCIPAL
+func (ls *LocalStore) DisablePrincipal(username string) error {
+	if !ls.userExists(username) || isReservedUser(username) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	ls.disabled[username] = true
+	return nil
+}
+
+// enable principal p
+// Enables the principal p disabled by 'disable principal' command.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone.
+// Security violation if the current principal is not admin.
+// Successful status code: ENABLE_PRINCIPAL
+func (ls *LocalStore) EnablePrincipal(username string) error {
+	if !ls.userExists(username) || isReservedUser(username) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	delete(ls.disabled, username)
+	return nil
+}
+
+// create group g
+// Creates the group g, which can be used as target of delegations.
+// Failure conditions:
+// Fails if principal or group g already exists, or g is all.
+// Security violation if the current principal is not admin and does not have groups capability.
+// Successful status code: CREATE_GROUP
+func (ls *LocalStore) CreateGroup(group string) error {
+	if ls.userExists(group) || ls.isGroup(group) || group == allVars {
+		return ErrFailed
+	}
+	if !ls.hasCapability(CapabilityGroups, "create group "+group) {
+		return ErrDenied
+	}
+	ls.groups[group] = make(map[string]bool)
+	return nil
+}
+
+// add p to group g
+// Adds the principal or group p to the group g.
+// Failure conditions:
+// Fails if p or g does not exist, p is anyone, or adding p would make a cycle of groups.
+// Security violation if the current principal is not admin and does not have groups capability.
+// Successful status code: ADD_TO_GROUP
+func (ls *LocalStore) AddToGroup(member string, group string) error {
+	if !ls.isGroup(group) || (!ls.userExists(member) && !ls.isGroup(member)) || member == anyoneUsername {
+		return ErrFailed
+	}
+	if member == group || ls.isGroup(member) && ls.groupContains(member, group) {
+		return ErrFailed
+	}
+	if !ls.hasCapability(CapabilityGroups, "add "+member+" to group "+group) {
+		return ErrDenied
+	}
+	ls.groups[group][member] = true
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// remove p from group g
+// Removes the principal or group p from the group g.
+// Failure conditions:
+// Fails if g does not exist or p is not a member of g.
+// Security violation if the current principal is not admin and does not have groups capability.
+// Successful status code: REMOVE_FROM_GROUP
+func (ls *LocalStore) RemoveFromGroup(member string, group string) error {
+	if !ls.isGroup(group) || !ls.groups[group][member] {
+		return ErrFailed
+	}
+	if !ls.hasCapability(CapabilityGroups, "remove "+member+" from group "+group) {
+		return ErrDenied
+	}
+	delete(ls.groups[group], member)
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// create namespace n
+// Creates the namespace n for variables n::x. If the current principal is not admin, it is delegated all rights
+// and the create right from the admin on the namespace. Delegations on the namespace
+// (set delegation namespace n q <right> -> p) are copied to variables created in the namespace.
+// Failure conditions:
+// Fails if namespace n already exists.
+// Security violation if creation is restricted and the current principal does not have create permission
+// on the root namespace.
+// Successful status code: CREATE_NAMESPACE
+func (ls *LocalStore) CreateNamespace(n string) error {
+	if ls.namespaces[n] || strings.Contains(n, namespaceSep) {
+		return ErrFailed
+	}
+	if ls.restrictCreation && !ls.HasPermission(rootNamespace, ls.currUserName, PermissionCreate) {
+		return ErrDenied
+	}
+	ls.namespaces[n] = true
+	key := n + namespaceSep
+	ls.assertions[key] = PermRecords{}
+	if !ls.IsAdmin() {
+		ls.addAssertion(key, adminUsername, PermissionAll|PermissionCreate, ls.currUserName)
+	}
+	return nil
+}
+
+// default creation = open|restricted
+// If creation is restricted, a new global variable or namespace can be created only by admin or by principal
+// having create permission on the namespace of the variable. Create permission for not namespaced variables
+// and namespaces is delegated by 'set delegation all admin create -> p'. Creation is open by default.
+// Failure conditions:
+// Security violation if the current principal is not admin.
+// Successful status code: DEFAULT_CREATION
+func (ls *LocalStore) SetRestrictedCreation(restrict bool) error {
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	ls.restrictCreation = restrict
+	return nil
+}
+
+// grant capability <cap> to p
+// Grants the administrative capability to the principal p.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone.
+// Security violation if the current principal is not admin.
+// Successful status code: GRANT_CAPABILITY
+func (ls *LocalStore) GrantCapability(c Capability, username string) error {
+	if !ls.userExists(username) || isReservedUser(username) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	ls.capabilities[username] |= c
+	ls.audit("admin granted capability %v to %s", c, username)
+	return nil
+}
+
+// revoke capability <cap> from p
+// Revokes the administrative capability from the principal p.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone.
+// Security violation if the current principal is not admin.
+// Successful status code: REVOKE_CAPABILITY
+func (ls *LocalStore) RevokeCapability(c Capability, username string) error {
+	if !ls.userExists(username) || isReservedUser(username) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	ls.capabilities[username] &^= c
+	if ls.capabilities[username] == 0 {
+		delete(ls.capabilities, username)
+	}
+	ls.audit("admin revoked capability %v from %s", c, username)
+	return nil
+}
+
+// set quota p <kind> = n
+// Sets the quota of the kind for the principal p, 0 means unlimited. The quota is checked on changes of
+// global variables created by p, existing values exceeding the quota are kept.
+// Failure conditions:
+// Fails if p does not exist or p is admin or anyone, or n is negative.
+// Security violation if the current principal is not admin.
+// Successful status code: SET_QUOTA
+func (ls *LocalStore) SetQuota(username string, kind QuotaKind, n int64) error {
+	if !ls.userExists(username) || isReservedUser(username) || n < 0 {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		return ErrDenied
+	}
+	if ls.quotas[username] == nil {
+		ls.quotas[username] = make(Quotas)
+	}
+	ls.quotas[username][kind] = n
+	return nil
+}
+
+// returns quota of the kind for the principal, own quota overrides the default one
+func (ls *LocalStore) quota(username string, kind QuotaKind) int64 {
+	if n, ok := ls.quotas[username][kind]; ok {
+		return n
+	}
+	return ls.global.defaultQuotas[kind]
+}
+
+// Checks that storing val to the global variable x does not exceed quotas of the principal who created x
+// (the current principal for a new variable). Variables of admin are not limited.
+func (ls *LocalStore) checkQuota(x string, val interface{}) error {
+	owner, ok := ls.owners[x]
+	isNew := !ok && !ls.isGlobalVarExist(x)
+	if isNew {
+		owner = ls.currUserName
+	}
+	if owner == "" || owner == adminUsername {
+		return nil
+	}
+	if limit := ls.quota(owner, QuotaLength); limit > 0 {
+		if lst, ok := val.(ListVal); ok && listLength(lst) > limit {
+			return ls.quotaExceeded(owner, QuotaLength, x)
+		}
+	}
+	varsLimit, bytesLimit := ls.quota(owner, QuotaVariables), ls.quota(owner, QuotaBytes)
+	if (varsLimit == 0 || !isNew) && bytesLimit == 0 {
+		return nil
+	}
+	count, size := int64(0), valueSize(val)
+	for n, u := range ls.owners {
+		if u != owner {
+			continue
+		}
+		count++
+		if v, ok := ls.lookup(n); ok && n != x {
+			size += valueSize(v)
+		}
+	}
+	if isNew && varsLimit > 0 && count >= varsLimit {
+		return ls.quotaExceeded(owner, QuotaVariables, x)
+	}
+	if bytesLimit > 0 && size > bytesLimit {
+		return ls.quotaExceeded(owner, QuotaBytes, x)
 	}
 	return nil
 }
 
+func (ls *LocalStore) quotaExceeded(owner string, kind QuotaKind, x string) error {
+	log.Printf("quota: %s quota of %s exceeded by %s on %s", kind, owner, ls.currUserName, x)
+	return ErrQuota
+}
+
+// Reports if the current principal is admin or has the capability. Use of the capability by other principal
+// than admin is audited with action description.
+func (ls *LocalStore) hasCapability(c Capability, action string) bool {
+	if ls.IsAdmin() {
+		return true
+	}
+	if ls.capabilities[ls.currUserName]&c == 0 {
+		return false
+	}
+	ls.audit("%s used capability %v: %s", ls.currUserName, c, action)
+	return true
+}
+
+// Queues capability audit record, records are logged on commit so that actions of failed programs are not logged
+func (ls *LocalStore) audit(format string, args ...interface{}) {
+	ls.auditLog = append(ls.auditLog, fmt.Sprintf(format, args...))
+}
+
 // change password p s
 // Changes the principal p’s password to s.
 // Failure conditions:
 // Fails if p does not exist
-// Security violation if the current principal is neither admin nor p itself.
+// Security violation if the current principal is neither admin nor p itself, and does not have passwords
+// capability. The capability does not allow to change password of admin or anyone.
 // Successful status code: CHANGE_PASSWORD
 func (ls *LocalStore) ChangePassword(username string, password string) error {
 	if !ls.userExists(username) {
 		return ErrFailed
 	}
-	if !ls.IsAdmin() && username != ls.currUserName {
+	if username != ls.currUserName && (isReservedUser(username) && !ls.IsAdmin() ||
+		!ls.hasCapability(CapabilityPasswords, "change password "+username)) {
 		return ErrDenied
 	}
 	if _, ok := ls.users[username]; ok { // change password for local user
@@ -219,7 +778,10 @@ func (ls *LocalStore) ChangePassword(username string, password string) error {
 //and the current principal is not admin, then the current principal is delegated read, write,
 // append, and delegate rights from the admin on x (equivalent to executing set delegation
 // x admin read -> p and set delegation x admin write -> p, etc. where p is the current principal).
+//If x is namespaced n::y, it gets delegations of the namespace n too.
 //Failure conditions:
+//Fails if x is created and namespace of x does not exist.
+//Fails if the new value exceeds quotas of the principal who created x or the store size limit.
 //Security violation if the current principal does not have write permission on x.
 //Successful status code: SET
 func (ls *LocalStore) Set(x string, val interface{}) error {
@@ -227,16 +789,30 @@ func (ls *LocalStore) Set(x string, val interface{}) error {
 		if !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
 			return ErrDenied
 		}
-		ls.vars[x] = val
-	} else if _, ok := ls.global.vars[x]; ok { // global variable exists
+		if err := ls.checkQuota(x, val); err != nil {
+			return err
+		}
+		return ls.putGlobal(x, val)
+	} else if _, ok := ls.globalVar(x); ok { // global variable exists
 		if !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
 			return ErrDenied
 		}
-		ls.vars[x] = val
+		if err := ls.checkQuota(x, val); err != nil {
+			return err
+		}
+		return ls.putGlobal(x, val)
 	} else if _, ok := ls.locals[x]; ok { // local variable exists
-		ls.locals[x] = val
+		return ls.putLocal(x, val)
 	} else { // new global variable
-		ls.vars[x] = val
+		if err := ls.checkCreate(x); err != nil {
+			return err
+		}
+		if err := ls.checkQuota(x, val); err != nil {
+			return err
+		}
+		if err := ls.putGlobal(x, val); err != nil {
+			return err
+		}
 		ls.setPermissionOnNewVariable(x)
 	}
 	return nil
@@ -249,10 +825,10 @@ func (ls *LocalStore) Set(x string, val interface{}) error {
 // Different from a global variable, local variables are destroyed when the program ends—they
 // do not persist across connections.
 // Failure conditions:
-// Fails if x is already defined as a local or global variable.
+// Fails if x is already defined as a local or global variable, or the store size limit is exceeded.
 // Successful status code: LOCAL
 func (ls *LocalStore) SetLocal(x string, val interface{}) error {
-	if _, ok := ls.global.vars[x]; ok { // global variable exists
+	if _, ok := ls.globalVar(x); ok { // global variable exists
 		return ErrFailed
 	}
 	if _, ok := ls.vars[x]; ok { // pending variable exists
@@ -261,45 +837,161 @@ func (ls *LocalStore) SetLocal(x string, val interface{}) error {
 	if _, ok := ls.locals[x]; ok { // local variable exists
 		return ErrFailed
 	}
-	ls.locals[x] = val
-	return nil
+	return ls.putLocal(x, val)
 }
 
-// get variable (local or global)
-func (ls *LocalStore) Get(x string) (interface{}, error) {
-	if v, ok := ls.locals[x]; ok { // local variable exists
-		return v, nil
-	} else if v, ok := ls.vars[x]; ok { // pending variable exists
-		if !ls.HasPermission(x, ls.currUserName, PermissionRead) {
-			return nil, ErrDenied
-		}
-		return v, nil
-	} else if v, ok := ls.global.vars[x]; ok { // global variable exists
-		if !ls.HasPermission(x, ls.currUserName, PermissionRead) {
-			return nil, ErrDenied
-		}
-		return v, nil
+// delete x
+// Deletes the variable x. If x is a global variable, all delegation assertions on x are deleted too,
+// so the variable created later with the same name gets only default delegations.
+// Failure conditions:
+// Fails if x does not exist.
+// Security violation if x is a global variable and the current principal does not have write permission on x.
+// Successful status code: DELETE
+func (ls *LocalStore) DeleteVar(x string) error {
+	if ls.isLocal(x) {
+		ls.remove(x)
+		return nil
 	}
-	return nil, ErrFailed
+	if !ls.isGlobalVarExist(x) {
+		return ErrFailed
+	}
+	if !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
+		return ErrDenied
+	}
+	ls.remove(x)
+	delete(ls.owners, x)
+	delete(ls.assertions, x)
+	delete(ls.denials, x)
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
 }
 
-// append to x with <expr>
-// Adds the <expr>’s result to the end of x.   If <expr> evaluates to a record or a string,
-// it is added to the end of x; if <expr> evaluates to a list, then it is concatenated to (the end of) x.
+// rename x to y
+// Renames the variable x to y. For a global variable the delegation assertions of x are moved to y.
 // Failure conditions:
-// Fails if x is not defined or is not a list.
-// Security violation if the current principal does not have either write or append permission on x.
-// Successful status code: APPEND
-func (ls *LocalStore) AppendTo(x string, val interface{}) error {
-	if !ls.IsVarExist(x) {
+// Fails if x does not exist, y already exists or namespace of global y does not exist,
+// or the store size limit is exceeded.
+// Security violation if the current principal does not have write and delegate permission on x.
+// Successful status code: RENAME
+func (ls *LocalStore) RenameVar(x string, y string) error {
+	if !ls.IsVarExist(x) || ls.IsVarExist(y) {
 		return ErrFailed
 	}
-	if l, ok := ls.locals[x]; ok { // local variable exists
-		toAppend, ok := l.(ListVal)
-		if !ok {
-			return ErrFailed
-		}
-		ls.locals[x] = append(toAppend, val)
+	if ls.isLocal(x) {
+		val := ls.locals[x]
+		ls.remove(x)
+		return ls.putLocal(y, val)
+	}
+	if err := ls.checkCreate(y); err != nil {
+		return err
+	}
+	if !ls.HasPermission(x, ls.currUserName, PermissionWrite) ||
+		!ls.HasPermission(x, ls.currUserName, PermissionDelegate) {
+		return ErrDenied
+	}
+	val, _ := ls.lookup(x)
+	ls.remove(x)
+	if err := ls.putGlobal(y, val); err != nil {
+		return err
+	}
+	if owner, ok := ls.owners[x]; ok {
+		ls.owners[y] = owner
+		delete(ls.owners, x)
+	}
+	ls.assertions[y] = ls.assertions[x]
+	delete(ls.assertions, x)
+	if denials, ok := ls.denials[x]; ok {
+		ls.denials[y] = denials
+		delete(ls.denials, x)
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// copy x to y [with delegations]
+// Copies the value of x to the new global variable y. y gets permissions as a variable created by set command,
+// if withDelegations is true the delegation assertions of x are copied to y too.
+// Failure conditions:
+// Fails if x does not exist, y already exists or namespace of y does not exist, or the copy exceeds quotas
+// or the store size limit.
+// Security violation if x is a global variable and the current principal does not have read, write and
+// delegate permission on x.
+// Successful status code: COPY
+func (ls *LocalStore) CopyVar(x string, y string, withDelegations bool) error {
+	val, ok := ls.lookup(x)
+	if !ok || ls.IsVarExist(y) {
+		return ErrFailed
+	}
+	if err := ls.checkCreate(y); err != nil {
+		return err
+	}
+	if !ls.isLocal(x) && (!ls.HasPermission(x, ls.currUserName, PermissionRead) ||
+		!ls.HasPermission(x, ls.currUserName, PermissionWrite) ||
+		!ls.HasPermission(x, ls.currUserName, PermissionDelegate)) {
+		return ErrDenied
+	}
+	if err := ls.checkQuota(y, val); err != nil {
+		return err
+	}
+	if err := ls.putGlobal(y, copyValue(val)); err != nil {
+		return err
+	}
+	ls.setPermissionOnNewVariable(y)
+	if withDelegations && !ls.isLocal(x) {
+		for targetUser, pPermRec := range ls.assertions[x] {
+			for perm, pOwnerRec := range pPermRec {
+				for owner, expires := range pOwnerRec {
+					ls.addExpiringAssertion(y, owner, perm, targetUser, expires)
+				}
+			}
+		}
+		if denials, ok := ls.denials[x]; ok {
+			ls.denials[y] = copyPermRecords(denials)
+		}
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// get variable (local or global)
+func (ls *LocalStore) Get(x string) (interface{}, error) {
+	if v, ok := ls.locals[x]; ok { // local variable exists
+		return v, nil
+	} else if v, ok := ls.vars[x]; ok { // pending variable exists
+		if !ls.HasPermission(x, ls.currUserName, PermissionRead) {
+			return nil, ErrDenied
+		}
+		return v, nil
+	} else if v, ok := ls.globalVar(x); ok { // global variable exists
+		if !ls.HasPermission(x, ls.currUserName, PermissionRead) {
+			return nil, ErrDenied
+		}
+		return v, nil
+	}
+	return nil, ErrFailed
+}
+
+// append to x with <expr>
+// Adds the <expr>’s result to the end of x.   If <expr> evaluates to a record or a string,
+// it is added to the end of x; if <expr> evaluates to a list, then it is concatenated to (the end of) x.
+// Failure conditions:
+// Fails if x is not defined or is not a list, or the new value exceeds quotas of the principal who created x
+// or the store size limit.
+// Security violation if the current principal does not have either write or append permission on x.
+// Successful status code: APPEND
+func (ls *LocalStore) AppendTo(x string, val interface{}) error {
+	if !ls.IsVarExist(x) {
+		return ErrFailed
+	}
+	if l, ok := ls.locals[x]; ok { // local variable exists
+		toAppend, ok := l.(ListVal)
+		if !ok {
+			return ErrFailed
+		}
+		return ls.write(x, append(toAppend, val), true, valueSize(val))
 	} else {
 		if !ls.HasPermission(x, ls.currUserName, PermissionWrite) &&
 			!ls.HasPermission(x, ls.currUserName, PermissionAppend) {
@@ -310,33 +1002,217 @@ func (ls *LocalStore) AppendTo(x string, val interface{}) error {
 			if !ok {
 				return ErrFailed
 			}
-			ls.vars[x] = append(toAppend, val)
-		} else if g, ok := ls.global.vars[x]; ok { // global variable exists
+			newLst := append(toAppend, val)
+			if err := ls.checkQuota(x, newLst); err != nil {
+				return err
+			}
+			return ls.write(x, newLst, false, valueSize(val))
+		} else if g, ok := ls.globalVar(x); ok { // global variable exists
 			toAppend, ok := g.(ListVal)
 			if !ok {
 				return ErrFailed
 			}
-			lstCopy := make(ListVal, len(toAppend))
+			lstCopy := make(ListVal, len(toAppend), len(toAppend)+1)
 			copy(lstCopy, toAppend)
-			ls.vars[x] = append(lstCopy, val)
+			lstCopy = append(lstCopy, val)
+			if err := ls.checkQuota(x, lstCopy); err != nil {
+				return err
+			}
+			return ls.write(x, lstCopy, false, valueSize(val))
 		}
 	}
 	return nil
 }
 
+// set x.f = <expr>
+// Sets field f of the record x to the string val. The field is added if x has no such field.
+// Failure conditions:
+// Fails if x is not defined or is not a record.
+// Security violation if the current principal does not have write permission on x.
+// Successful status code: SET
+func (ls *LocalStore) SetField(x string, field string, val string) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
+		return ErrDenied
+	}
+	rec, ok := v.(RecordVal)
+	if !ok {
+		return ErrFailed
+	}
+	newRec := make(RecordVal, len(rec)+1)
+	for k, v := range rec {
+		newRec[k] = v
+	}
+	newRec[field] = val
+	return ls.Set(x, newRec)
+}
+
+// set x[i] = <expr>
+// Replaces the element of the list x at position i (counting from 0 in the flattened list,
+// negative positions are counted from the end) with val.
+// Failure conditions:
+// Fails if x is not defined, is not a list or has no element at position i.
+// Security violation if the current principal does not have write permission on x.
+// Successful status code: SET
+func (ls *LocalStore) SetIndex(x string, i int64, val interface{}) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
+		return ErrDenied
+	}
+	lst, ok := v.(ListVal)
+	if !ok {
+		return ErrFailed
+	}
+	newLst := lst.Flatten()
+	if i < 0 {
+		i += int64(len(newLst))
+	}
+	if i < 0 || i >= int64(len(newLst)) {
+		return ErrFailed
+	}
+	newLst[i] = val
+	return ls.Set(x, newLst)
+}
+
+// append to x.f with <expr>
+// Adds the string val to the end of the field f of the record x. The field is added if x has no such field.
+// The resulting string is truncated to 65535 characters (if it would exceed that length).
+// Failure conditions:
+// Fails if x is not defined or is not a record, or the new value exceeds quotas of the principal who created x
+// or the store size limit.
+// Security violation if the current principal does not have write permission on x.
+// Successful status code: APPEND
+func (ls *LocalStore) AppendToField(x string, field string, val string) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) {
+		return ErrDenied
+	}
+	rec, ok := v.(RecordVal)
+	if !ok {
+		return ErrFailed
+	}
+	newRec := make(RecordVal, len(rec)+1)
+	for k, v := range rec {
+		newRec[k] = v
+	}
+	newVal := rec[field] + val
+	if len(newVal) > MaxStringLength {
+		newVal = newVal[:MaxStringLength]
+	}
+	newRec[field] = newVal
+	return ls.Set(x, newRec)
+}
+
+// prepend to x with <expr>
+// Adds the val to the beginning of x. If val is a list, then it is concatenated to (the beginning of) x.
+// Failure conditions:
+// Fails if x is not defined or is not a list, or the new value exceeds quotas of the principal who created x
+// or the store size limit.
+// Security violation if the current principal does not have either write or append permission on x.
+// Successful status code: PREPEND
+func (ls *LocalStore) PrependTo(x string, val interface{}) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) &&
+		!ls.HasPermission(x, ls.currUserName, PermissionAppend) {
+		return ErrDenied
+	}
+	lst, ok := v.(ListVal)
+	if !ok {
+		return ErrFailed
+	}
+	newLst := make(ListVal, 0, len(lst)+1)
+	newLst = append(append(newLst, val), lst...)
+	if !ls.isLocal(x) {
+		if err := ls.checkQuota(x, newLst); err != nil {
+			return err
+		}
+	}
+	return ls.put(x, newLst)
+}
+
+// pop x
+// Removes the last element of the (flattened) list x.
+// Failure conditions:
+// Fails if x is not defined, is not a list or is empty.
+// Security violation if the current principal does not have either write or remove permission on x.
+// Successful status code: POP
+func (ls *LocalStore) Pop(x string) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) &&
+		!ls.HasPermission(x, ls.currUserName, PermissionRemove) {
+		return ErrDenied
+	}
+	lst, ok := v.(ListVal)
+	if !ok {
+		return ErrFailed
+	}
+	newLst := lst.Flatten()
+	if len(newLst) == 0 {
+		return ErrFailed
+	}
+	return ls.put(x, newLst[:len(newLst)-1])
+}
+
+// remove from x where y => <expr>
+// Removes all elements of the (flattened) list x for which remove returns true.
+// Failure conditions:
+// Fails if x is not defined or is not a list, or remove returns an error.
+// Security violation if the current principal does not have either write or remove permission on x.
+// Successful status code: REMOVE
+func (ls *LocalStore) RemoveFrom(x string, remove func(val interface{}) (bool, error)) error {
+	v, ok := ls.lookup(x)
+	if !ok {
+		return ErrFailed
+	}
+	if !ls.isLocal(x) && !ls.HasPermission(x, ls.currUserName, PermissionWrite) &&
+		!ls.HasPermission(x, ls.currUserName, PermissionRemove) {
+		return ErrDenied
+	}
+	lst, ok := v.(ListVal)
+	if !ok {
+		return ErrFailed
+	}
+	newLst := make(ListVal, 0, len(lst))
+	for _, val := range lst.Flatten() {
+		res, err := remove(val)
+		if err != nil {
+			return err
+		}
+		if !res {
+			newLst = append(newLst, val)
+		}
+	}
+	return ls.put(x, newLst)
+}
+
 // Sets the “default delegator” to p. This means that when a principal q is created,
 // the system automatically delegates all from p to q. Changing the default delegator does not affect the
 // permissions of existing principals. The initial default delegator is anyone.
 // Failure conditions:
 // Fails if p does not exist
-// Security violation if the current principal is not admin.
+// Security violation if the current principal is not admin and does not have delegator capability.
 // Successful status code: DEFAULT_DELEGATOR
 // cmd: default delegator = p
 func (ls *LocalStore) SetDefaultDelegator(p string) error {
 	if !ls.userExists(p) {
 		return ErrFailed
 	}
-	if !ls.IsAdmin() {
+	if !ls.hasCapability(CapabilityDelegator, "default delegator = "+p) {
 		return ErrDenied
 	}
 	ls.defaultDelegator = p
@@ -361,8 +1237,133 @@ func (ls *LocalStore) getDefaultDelegator() string {
 // cmd: set delegation <tgt> q <right> -> p
 // variable mapping: set delegation varname owner right -> targetUser
 func (ls *LocalStore) SetDelegation(varname string, owner string, perm Permission, targetUser string) error {
-	//check that target and owner user exist
-	if !ls.userExists(targetUser) || !ls.userExists(owner) {
+	return ls.SetDelegationExpiring(varname, owner, perm, targetUser, time.Time{})
+}
+
+// set delegation "pattern" q <right> -> p [for "d" | until "t"]
+// Delegates <right> from q to p on all existing and future variables with names matching the pattern.
+// Pattern is anchored and may contain wildcards * (any sequence of characters) and ? (one character).
+// The delegation applies to variable x only while q has delegate permission on x.
+// Zero expires means no expiration.
+// Failure conditions:
+// Fails if either p or q does not exist, pattern is invalid or expires is not in the future.
+// Security violation if the running principal is not admin or q.
+// Successful status code: SET_DELEGATION
+func (ls *LocalStore) SetPatternDelegation(pattern string, owner string, perm Permission, targetUser string,
+	expires time.Time) error {
+	if !ls.targetExists(targetUser) || !ls.userExists(owner) || !validPattern(pattern) || ls.isExpired(expires) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() && ls.currUserName != owner {
+		return ErrDenied
+	}
+	for _, p := range perm.Split() {
+		addPermRecord(ls.patterns, pattern, owner, p, targetUser, expires)
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// delete delegation "pattern" q <right> -> p
+// Deletes delegation set by 'set delegation "pattern" q <right> -> p'.
+// Failure conditions:
+// Fails if either p or q does not exist or pattern is invalid.
+// Security violation if the running principal is not admin, q or p.
+// Successful status code: DELETE_DELEGATION
+func (ls *LocalStore) DeletePatternDelegation(pattern string, owner string, perm Permission,
+	targetUser string) error {
+	if !ls.targetExists(targetUser) || !ls.userExists(owner) || !validPattern(pattern) {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() && ls.currUserName != owner && ls.currUserName != targetUser {
+		return ErrDenied
+	}
+	for _, p := range perm.Split() {
+		delete(ls.patterns[pattern][targetUser][p], owner)
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// set denial x q <right> -> p
+// Blocks <right> of p on x regardless of delegations. If p is a group or anyone, the right is blocked for all
+// its members or all principals except admin. Like a pattern delegation, the denial applies only while q
+// has delegate permission on x.
+// Failure conditions:
+// Fails if either p or q does not exist, x is not a global variable or p is admin.
+// Security violation if the running principal is not admin or q, or if q does not have delegate permission on x.
+// Successful status code: SET_DENIAL
+func (ls *LocalStore) SetDenial(varname string, owner string, perm Permission, targetUser string) error {
+	if err := ls.checkDenialArgs(varname, owner, targetUser); err != nil {
+		return err
+	}
+	for _, p := range perm.Split() {
+		addPermRecord(ls.denials, varname, owner, p, targetUser, time.Time{})
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+// delete denial x q <right> -> p
+// Deletes denial set by 'set denial x q <right> -> p'.
+// Failure conditions and security violations are the same as for SetDenial.
+// Successful status code: DELETE_DENIAL
+func (ls *LocalStore) DeleteDenial(varname string, owner string, perm Permission, targetUser string) error {
+	if err := ls.checkDenialArgs(varname, owner, targetUser); err != nil {
+		return err
+	}
+	for _, p := range perm.Split() {
+		delete(ls.denials[varname][targetUser][p], owner)
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+	return nil
+}
+
+func (ls *LocalStore) checkDenialArgs(varname string, owner string, targetUser string) error {
+	if !ls.targetExists(targetUser) || !ls.userExists(owner) || !ls.isGlobalVarExist(varname) ||
+		targetUser == adminUsername {
+		return ErrFailed
+	}
+	if !ls.IsAdmin() {
+		if ls.currUserName != owner || !ls.HasPermission(varname, owner, PermissionDelegate) {
+			return ErrDenied
+		}
+	}
+	return nil
+}
+
+// Reports if right of username on varname is blocked by denial to username, its groups or anyone
+// whose owner still has delegate permission on varname
+func (ls *LocalStore) isDenied(varname string, username string, perm Permission) bool {
+	k := PermCacheKey{username: username, varname: varname, perm: perm}
+	if ls.denialChecks[k] { // denials depending on each other do not apply
+		return false
+	}
+	ls.denialChecks[k] = true
+	defer delete(ls.denialChecks, k)
+	for _, target := range ls.permissionTargets(username) {
+		for owner := range ls.denials[varname][target][perm] {
+			if owner == adminUsername || ls.HasPermission(varname, owner, PermissionDelegate) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// set delegation <tgt> q <right> -> p for "d" | until "t"
+// Same as SetDelegation, but the delegation is ignored from expires time and deleted on commit.
+// Zero expires means no expiration.
+// Failure conditions:
+// Fails as SetDelegation, or if expires is not in the future.
+func (ls *LocalStore) SetDelegationExpiring(varname string, owner string, perm Permission, targetUser string,
+	expires time.Time) error {
+	//check that target and owner user exist, target can be a group
+	if !ls.targetExists(targetUser) || !ls.userExists(owner) || ls.isExpired(expires) {
 		return ErrFailed
 	}
 	// Handle special case
@@ -373,17 +1374,15 @@ func (ls *LocalStore) SetDelegation(varname string, owner string, perm Permissio
 		if !ls.IsAdmin() && ls.currUserName != owner {
 			return ErrDenied
 		}
-		// Find all varname where owner has DelegatePermission and issue add delegate cmd for this varname
-		// We don't check return value since we already pass all checks and afaik we have delegate Permission
-		for v, _ := range ls.assertions {
-			if ls.HasPermission(v, owner, PermissionDelegate) {
-				ls.SetDelegation(v, owner, perm, targetUser)
-			}
-		}
+		ls.addAllAssertions(owner, perm, targetUser, expires)
 		return nil
 	}
+	//create right can be delegated on namespaces only
+	if perm.IsSet(PermissionCreate) && !isNamespaceKey(varname) {
+		return ErrFailed
+	}
 	//do not allow set delegation on local vars
-	if !ls.isGlobalVarExist(varname) {
+	if !ls.isGlobalVarExist(varname) && !ls.namespaceKeyExists(varname) {
 		return ErrFailed
 	}
 	//Check permissions to do this operation
@@ -392,12 +1391,31 @@ func (ls *LocalStore) SetDelegation(varname string, owner string, perm Permissio
 			return ErrDenied
 		}
 	}
-	ls.addAssertion(varname, owner, perm, targetUser)
+	ls.addExpiringAssertion(varname, owner, perm, targetUser, expires)
 	//invalidate permission cache
 	ls.permissionCache = make(map[PermCacheKey]bool)
 	return nil
 }
 
+// Delegates perm from owner to targetUser on all variables and namespaces where owner has delegate permission,
+// create right applies to namespaces, other rights to variables
+func (ls *LocalStore) addAllAssertions(owner string, perm Permission, targetUser string, expires time.Time) {
+	for v := range ls.assertions {
+		if !ls.HasPermission(v, owner, PermissionDelegate) {
+			continue
+		}
+		if isNamespaceKey(v) {
+			if perm.IsSet(PermissionCreate) && ls.namespaceKeyExists(v) {
+				ls.addExpiringAssertion(v, owner, PermissionCreate, targetUser, expires)
+			}
+		} else if perm&^PermissionCreate != 0 && ls.isGlobalVarExist(v) {
+			ls.addExpiringAssertion(v, owner, perm&^PermissionCreate, targetUser, expires)
+		}
+	}
+	//invalidate permission cache
+	ls.permissionCache = make(map[PermCacheKey]bool)
+}
+
 // When <tgt> is a variable x, indicates that q revokes a delegation assertion of <right> to p on x.
 // In effect, this command revokes a previous command set delegation x q <right> -> p; see below for
 // the precise semantics of what this means. If <tgt> is the keyword all then q revokes delegation of
@@ -411,12 +1429,12 @@ func (ls *LocalStore) SetDelegation(varname string, owner string, perm Permissio
 // Successful status code: DELETE_DELEGATION
 // cmd: delete delegation <tgt> q <right> -> p
 func (ls *LocalStore) DeleteDelegation(varname string, owner string, perm Permission, targetUser string) error {
-	//check that target and owner user exist
-	if !ls.userExists(targetUser) || !ls.userExists(owner) {
+	//check that target and owner user exist, target can be a group
+	if !ls.targetExists(targetUser) || !ls.userExists(owner) {
 		return ErrFailed
 	}
-	//check that varname exists
-	if !ls.isGlobalVarExist(varname) {
+	//check that varname or namespace exists
+	if !ls.isGlobalVarExist(varname) && !ls.namespaceKeyExists(varname) {
 		return ErrFailed
 	}
 	//can't remove permission from admin
@@ -451,6 +1469,7 @@ func (ls *LocalStore) DeleteDelegation(varname string, owner string, perm Permis
 	return nil
 }
 
+// Reports if username has perm on varname, perm must be a single right
 func (ls *LocalStore) HasPermission(varname string, username string, perm Permission) bool {
 	//admin always have all permissions
 	if username == adminUsername {
@@ -460,48 +1479,364 @@ func (ls *LocalStore) HasPermission(varname string, username string, perm Permis
 	if res, ok := ls.CheckPermInCache(varname, username, perm); ok {
 		return res
 	}
+	//denial overrides all delegations
+	if ls.isDenied(varname, username, perm) {
+		return ls.addToPermCacheReturn(varname, username, perm, false)
+	}
 
-	// We look for record with delegate varname someone permission -> username (or anyone)
+	// We look for record with delegate varname someone permission -> username (or its groups, or anyone)
 	// if someone is admin => return true
-	r1, ok := ls.assertions[varname][username]
-	if ok {
-		r2, ok := r1[perm]
-		if ok {
-			for owner, _ := range r2 {
-				if owner == adminUsername {
-					return ls.addToPermCacheReturn(varname, username, perm, true)
-				}
-				k := PermVisitedKey{varname: varname, targetUser: username, owner: owner, perm: perm}
-				if _, ok = ls.visitedAssertions[k]; ok {
-					continue
-				}
-				ls.visitedAssertions[k] = true
-				if ls.HasPermission(varname, owner, perm) {
-					return ls.addToPermCacheReturn(varname, username, perm, true)
-				}
+	for _, target := range ls.permissionTargets(username) {
+		r2, ok := ls.assertions[varname][target][perm]
+		if !ok {
+			continue
+		}
+		for owner, expires := range r2 {
+			if ls.isExpired(expires) {
+				continue
+			}
+			if owner == adminUsername {
+				return ls.addToPermCacheReturn(varname, username, perm, true)
+			}
+			k := PermVisitedKey{varname: varname, targetUser: username, owner: owner, perm: perm}
+			if _, ok = ls.visitedAssertions[k]; ok {
+				continue
+			}
+			ls.visitedAssertions[k] = true
+			if ls.HasPermission(varname, owner, perm) {
+				return ls.addToPermCacheReturn(varname, username, perm, true)
 			}
 		}
 	}
-	r1, ok = ls.assertions[varname][anyoneUsername]
-	if ok { //anyoneUser
-		r2, ok := r1[perm]
-		if ok {
-			for owner, _ := range r2 {
-				if owner == adminUsername {
-					return ls.addToPermCacheReturn(varname, username, perm, true)
-				}
-				k := PermVisitedKey{varname: varname, targetUser: username, owner: owner, perm: perm}
-				if _, ok = ls.visitedAssertions[k]; ok {
-					continue
+	// Pattern delegations apply only if their owner has delegate permission on varname
+	for _, target := range ls.permissionTargets(username) {
+		for _, owner := range ls.patternOwners(varname, target, perm) {
+			if owner == adminUsername {
+				return ls.addToPermCacheReturn(varname, username, perm, true)
+			}
+			k := PermVisitedKey{varname: varname, targetUser: username, owner: owner, perm: perm}
+			if _, ok := ls.visitedAssertions[k]; ok {
+				continue
+			}
+			ls.visitedAssertions[k] = true
+			if ls.HasPermission(varname, owner, PermissionDelegate) && ls.HasPermission(varname, owner, perm) {
+				return ls.addToPermCacheReturn(varname, username, perm, true)
+			}
+		}
+	}
+	return ls.addToPermCacheReturn(varname, username, perm, false)
+}
+
+// Sorted owners of not expired pattern delegations of perm to target with pattern matching varname
+func (ls *LocalStore) patternOwners(varname string, target string, perm Permission) []string {
+	if isNamespaceKey(varname) { // patterns apply to variables only
+		return nil
+	}
+	var owners []string
+	for pattern, permRec := range ls.patterns {
+		if matchPattern(pattern, varname) {
+			owners = append(owners, ls.activeOwners(permRec[target][perm])...)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// Anchored match of name with pattern segment by segment, so wildcards never match the namespace separator:
+// "team::logs_*" matches "team::logs_1", but "logs_*" does not.
+func matchPattern(pattern string, name string) bool {
+	patternSegs, nameSegs := strings.Split(pattern, namespaceSep), strings.Split(name, namespaceSep)
+	if len(patternSegs) != len(nameSegs) {
+		return false
+	}
+	for i := range patternSegs {
+		if !matchSegment(patternSegs[i], nameSegs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Anchored match of name with pattern, where * matches any sequence of characters and ? matches one character.
+// Runs in O(len(pattern) * len(name)) time.
+func matchSegment(pattern string, name string) bool {
+	p, n := 0, 0
+	star, starN := -1, 0
+	for n < len(name) {
+		if p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]) {
+			p++
+			n++
+		} else if p < len(pattern) && pattern[p] == '*' {
+			star, starN = p, n
+			p++
+		} else if star >= 0 { // backtrack: * matches one more character
+			starN++
+			p, n = star+1, starN
+		} else {
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Pattern may contain only identifier characters, wildcards * and ? and one namespace separator n::y,
+// both namespace and name parts must not be empty
+func validPattern(pattern string) bool {
+	if pattern == "" || len(pattern) > maxPattern {
+		return false
+	}
+	segs := strings.Split(pattern, namespaceSep)
+	if len(segs) > 2 {
+		return false
+	}
+	for _, seg := range segs {
+		if seg == "" {
+			return false
+		}
+		for _, c := range seg {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' ||
+				c == '*' || c == '?') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// explain(x, p, right)
+// Returns the delegation paths from admin to p that grant the right on x, as list of strings
+// like "admin -> alice -> bob". A grant to a group or anyone is shown as "g -> p". If there is no such path
+// a string with the reason is returned.
+// Failure conditions:
+// Fails if x or p does not exist.
+// Security violation if the current principal is not admin.
+func (ls *LocalStore) ExplainPermission(varname string, username string, perm Permission) (interface{}, error) {
+	if !ls.IsAdmin() {
+		return nil, ErrDenied
+	}
+	if !ls.isGlobalVarExist(varname) || !ls.userExists(username) {
+		return nil, ErrFailed
+	}
+	if username != adminUsername && ls.isDenied(varname, username, perm) {
+		return "denied " + permName(perm) + " on " + varname + " to " + username, nil
+	}
+	search := &pathSearch{varname: varname, perm: perm, onPath: make(map[string]bool), budget: maxExplainNodes}
+	paths := ls.permissionPaths(search, username, maxExplainPaths)
+	if len(paths) == 0 {
+		owners := ls.directOwners(varname, username, perm)
+		if len(owners) == 0 {
+			return "no delegation of " + permName(perm) + " on " + varname + " to " + username + " or anyone", nil
+		}
+		return "no path to admin from delegators " + strings.Join(owners, ", ") + " of " + permName(perm) +
+			" on " + varname + " to " + username, nil
+	}
+	res := make(ListVal, len(paths))
+	for i, path := range paths {
+		res[i] = strings.Join(path, " -> ")
+	}
+	return res, nil
+}
+
+// permissions(x)
+// Returns list of records {right = <right name>, user = p}, one for each principal p having the right on x.
+// Records follow the order of rights, principals of a right are sorted, so
+// map r in filter r in permissions(x) where equal(r.right, "read") => r.user gives the readers of x.
+// The field is not named principal, as principal is a keyword.
+// Failure conditions:
+// Fails if x is not global variable.
+// Security violation if the current principal does not have delegate permission on x.
+func (ls *LocalStore) Permissions(varname string) (ListVal, error) {
+	if !ls.isGlobalVarExist(varname) {
+		return nil, ErrFailed
+	}
+	if !ls.HasPermission(varname, ls.currUserName, PermissionDelegate) {
+		return nil, ErrDenied
+	}
+	users := ls.allUsers()
+	res := ListVal{}
+	for _, perm := range allPermissions {
+		for _, u := range users {
+			if ls.HasPermission(varname, u, perm) {
+				res = append(res, RecordVal{"right": permName(perm), "user": u})
+			}
+		}
+	}
+	return res, nil
+}
+
+// rights(x)
+// Returns list of right names the current principal has on x.
+// Failure conditions:
+// Fails if x is not global variable.
+func (ls *LocalStore) Rights(varname string) (ListVal, error) {
+	if !ls.isGlobalVarExist(varname) {
+		return nil, ErrFailed
+	}
+	res := ListVal{}
+	for _, perm := range allPermissions {
+		if ls.HasPermission(varname, ls.currUserName, perm) {
+			res = append(res, permName(perm))
+		}
+	}
+	return res, nil
+}
+
+// export delegations
+// Returns all delegation assertions sorted by variable, owner, target and right.
+// Delegations on namespaces are marked as such, see DelegationEdge.
+// Failure conditions:
+// Security violation if the current principal is not admin.
+func (ls *LocalStore) Delegations() ([]DelegationEdge, error) {
+	if !ls.IsAdmin() {
+		return nil, ErrDenied
+	}
+	res := ls.delegationEdges(nil, ls.assertions)
+	res = ls.delegationEdges(res, ls.patterns)
+	sort.Slice(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Var != b.Var {
+			return a.Var < b.Var
+		} else if a.Owner != b.Owner {
+			return a.Owner < b.Owner
+		} else if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Right < b.Right
+	})
+	return res, nil
+}
+
+// Append not expired records of table to edges
+func (ls *LocalStore) delegationEdges(edges []DelegationEdge, table map[string]PermRecords) []DelegationEdge {
+	for varname, permRec := range table {
+		for targetUser, pPermRec := range permRec {
+			for perm, pOwnerRec := range pPermRec {
+				for owner, expires := range pOwnerRec {
+					if ls.isExpired(expires) {
+						continue
+					}
+					e := DelegationEdge{Var: varname, Owner: owner, Target: targetUser, Right: permName(perm),
+						Namespace: isNamespaceKey(varname)}
+					if !expires.IsZero() {
+						e.Expires = expires.Format(time.RFC3339)
+					}
+					edges = append(edges, e)
 				}
-				ls.visitedAssertions[k] = true
-				if ls.HasPermission(varname, owner, perm) {
-					return ls.addToPermCacheReturn(varname, username, perm, true)
+			}
+		}
+	}
+	return edges
+}
+
+// State of the delegation path search of explain
+type pathSearch struct {
+	varname string
+	perm    Permission
+	onPath  map[string]bool // principals of the current path, prevents cycles
+	budget  int             // principals left to visit, bounds the search in densely delegated graphs
+}
+
+// At most limit chains of principals from admin to username, each one delegating perm on varname to the next.
+// Follows the same assertions as HasPermission.
+func (ls *LocalStore) permissionPaths(search *pathSearch, username string, limit int) [][]string {
+	if username == adminUsername {
+		return [][]string{{adminUsername}}
+	}
+	if search.budget == 0 {
+		return nil
+	}
+	search.budget--
+	search.onPath[username] = true
+	defer delete(search.onPath, username)
+	var res [][]string
+	for _, target := range ls.permissionTargets(username) {
+		owners := ls.activeOwners(ls.assertions[search.varname][target][search.perm])
+		for _, owner := range ls.patternOwners(search.varname, target, search.perm) {
+			if owner == adminUsername || ls.HasPermission(search.varname, owner, PermissionDelegate) {
+				owners = append(owners, owner)
+			}
+		}
+		for _, owner := range owners {
+			if search.onPath[owner] || !ls.HasPermission(search.varname, owner, search.perm) {
+				continue
+			}
+			for _, path := range ls.permissionPaths(search, owner, limit-len(res)) {
+				if target != username {
+					path = append(path, target)
 				}
+				res = append(res, append(path, username))
+			}
+			if len(res) == limit {
+				return res
 			}
 		}
 	}
-	return ls.addToPermCacheReturn(varname, username, perm, false)
+	return res
+}
+
+// Principals delegating perm on varname to username, its groups or anyone
+func (ls *LocalStore) directOwners(varname string, username string, perm Permission) []string {
+	var owners []string
+	for _, target := range ls.permissionTargets(username) {
+		owners = append(owners, ls.activeOwners(ls.assertions[varname][target][perm])...)
+		owners = append(owners, ls.patternOwners(varname, target, perm)...)
+	}
+	return owners
+}
+
+// Sorted owners of not expired delegations
+func (ls *LocalStore) activeOwners(ownerRec map[string]time.Time) []string {
+	owners := make([]string, 0, len(ownerRec))
+	for owner, expires := range ownerRec {
+		if !ls.isExpired(expires) {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// Delegation targets granting rights to username: username itself, groups containing it and anyone
+func (ls *LocalStore) permissionTargets(username string) []string {
+	targets := []string{username}
+	targets = append(targets, ls.groupsOf(username)...)
+	if username != anyoneUsername {
+		targets = append(targets, anyoneUsername)
+	}
+	return targets
+}
+
+// Sorted groups containing member directly or through nested groups
+func (ls *LocalStore) groupsOf(member string) []string {
+	var res []string
+	found := map[string]bool{member: true}
+	queue := []string{member}
+	for len(queue) > 0 {
+		m := queue[0]
+		queue = queue[1:]
+		for g, members := range ls.groups {
+			if members[m] && !found[g] {
+				found[g] = true
+				res = append(res, g)
+				queue = append(queue, g)
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Reports if group contains member directly or through nested groups
+func (ls *LocalStore) groupContains(group string, member string) bool {
+	for _, g := range ls.groupsOf(member) {
+		if g == group {
+			return true
+		}
+	}
+	return false
 }
 
 func (ls *LocalStore) CheckPermInCache(varname string, username string, perm Permission) (bool, bool) {
@@ -518,70 +1853,182 @@ func (ls *LocalStore) addToPermCacheReturn(varname string, username string, perm
 }
 
 func (ls *LocalStore) addAssertion(varname string, owner string, perm Permission, targetUser string) {
-	_, ok := ls.assertions[varname][targetUser]
+	ls.addExpiringAssertion(varname, owner, perm, targetUser, time.Time{})
+}
+
+// Add assertion expiring at expires, zero time means no expiration. Replaces expiration of existing assertion.
+func (ls *LocalStore) addExpiringAssertion(varname string, owner string, perm Permission, targetUser string,
+	expires time.Time) {
+	for _, p := range perm.Split() {
+		addPermRecord(ls.assertions, varname, owner, p, targetUser, expires)
+	}
+}
+
+func addPermRecord(table map[string]PermRecords, varname string, owner string, perm Permission,
+	targetUser string, expires time.Time) {
+	if _, ok := table[varname]; !ok {
+		table[varname] = PermRecords{}
+	}
+	_, ok := table[varname][targetUser]
 	if !ok {
-		v := make(map[Permission]map[string]bool)
-		v[perm] = make(map[string]bool)
-		v[perm][owner] = true
-		ls.assertions[varname][targetUser] = v
+		v := make(map[Permission]map[string]time.Time)
+		v[perm] = make(map[string]time.Time)
+		v[perm][owner] = expires
+		table[varname][targetUser] = v
 		return
 	}
-	_, ok = ls.assertions[varname][targetUser][perm]
+	_, ok = table[varname][targetUser][perm]
 	if !ok {
-		v := make(map[string]bool)
-		v[owner] = true
-		ls.assertions[varname][targetUser][perm] = v
+		v := make(map[string]time.Time)
+		v[owner] = expires
+		table[varname][targetUser][perm] = v
 		return
 	}
-	_, ok = ls.assertions[varname][targetUser][perm][owner]
-	if !ok {
-		ls.assertions[varname][targetUser][perm][owner] = true
+	table[varname][targetUser][perm][owner] = expires
+}
+
+// Delete records where username is owner or target
+func deletePrincipalRecords(table map[string]PermRecords, username string) {
+	for _, permRec := range table {
+		delete(permRec, username) // username is target
+		for _, pPermRec := range permRec {
+			for _, pOwnerRec := range pPermRec {
+				delete(pOwnerRec, username) // username is owner
+			}
+		}
 	}
 }
 
 func (ls *LocalStore) deleteAssertion(varname string, owner string, perm Permission, targetUser string) {
-	_, ok := ls.assertions[varname][targetUser]
-	if !ok {
-		return
-	}
-	_, ok = ls.assertions[varname][targetUser][perm]
-	if !ok {
-		return
+	for _, p := range perm.Split() {
+		delete(ls.assertions[varname][targetUser][p], owner)
 	}
-	_, ok = ls.assertions[varname][targetUser][perm][owner]
-	if !ok {
-		return
+}
+
+func (ls *LocalStore) isExpired(expires time.Time) bool {
+	return !expires.IsZero() && !ls.Now().Before(expires)
+}
+
+// Delete expired assertions and pattern delegations, called on commit
+func (ls *LocalStore) deleteExpiredAssertions() {
+	ls.deleteExpiredRecords(ls.assertions)
+	ls.deleteExpiredRecords(ls.patterns)
+}
+
+func (ls *LocalStore) deleteExpiredRecords(table map[string]PermRecords) {
+	for _, permRec := range table {
+		for _, pPermRec := range permRec {
+			for _, pOwnerRec := range pPermRec {
+				for owner, expires := range pOwnerRec {
+					if ls.isExpired(expires) {
+						delete(pOwnerRec, owner)
+					}
+				}
+			}
+		}
 	}
-	delete(ls.assertions[varname][targetUser][perm], owner)
 }
 
 // Should be called after creating variable. From set cmd description
 // If x is created set command, and the current principal is not admin, then the current principal is
-// delegated read, write, append, and delegate rights from the admin on x (equivalent to executing set
+// delegated read, write, append, delegate and remove rights from the admin on x (equivalent to executing set
 // delegation x admin read -> p and set delegation x admin write -> p, etc. where p is the current principal).
+// Variable in namespace n gets a copy of delegations of the namespace too.
 func (ls *LocalStore) setPermissionOnNewVariable(varname string) {
-	ls.assertions[varname] = PermRecords{}
+	ls.owners[varname] = ls.currUserName
+	if n, ok := namespaceOf(varname); ok {
+		ls.assertions[varname] = copyPermRecords(ls.assertions[n+namespaceSep])
+		for _, pPermRec := range ls.assertions[varname] {
+			delete(pPermRec, PermissionCreate) // create right is not a variable right
+		}
+	} else {
+		ls.assertions[varname] = PermRecords{}
+	}
 	if ls.IsAdmin() {
 		return
 	}
-	ls.addAssertion(varname, adminUsername, PermissionRead, ls.currUserName)
-	ls.addAssertion(varname, adminUsername, PermissionWrite, ls.currUserName)
-	ls.addAssertion(varname, adminUsername, PermissionAppend, ls.currUserName)
-	ls.addAssertion(varname, adminUsername, PermissionDelegate, ls.currUserName)
+	ls.addAssertion(varname, adminUsername, PermissionAll, ls.currUserName)
 }
 
 func (ls *LocalStore) userExists(username string) bool {
 	if _, ok := ls.users[username]; ok { // exists as local user
 		return true
 	}
+	if ls.deletedUsers[username] { // pending delete
+		return false
+	}
 	if _, ok := ls.global.users[username]; ok { // exists user
 		return true
 	}
 	return false
 }
 
+// sorted names of global and pending principals
+func (ls *LocalStore) allUsers() []string {
+	users := make([]string, 0, len(ls.global.users)+len(ls.users))
+	for u := range ls.global.users {
+		if _, ok := ls.users[u]; !ok && !ls.deletedUsers[u] {
+			users = append(users, u)
+		}
+	}
+	for u := range ls.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
+
+func (ls *LocalStore) isGroup(name string) bool {
+	_, ok := ls.groups[name]
+	return ok
+}
+
+// delegation target can be a principal or a group
+func (ls *LocalStore) targetExists(name string) bool {
+	return ls.userExists(name) || ls.isGroup(name)
+}
+
+// admin and anyone can not be deleted or disabled
+func isReservedUser(username string) bool {
+	return username == adminUsername || username == anyoneUsername
+}
+
+// Namespace of new global variable must exist, and the current principal must have create permission on it
+// if creation is restricted
+func (ls *LocalStore) checkCreate(varname string) error {
+	key := rootNamespace
+	if n, ok := namespaceOf(varname); ok {
+		if !ls.namespaces[n] {
+			return ErrFailed
+		}
+		key = n + namespaceSep
+	}
+	if ls.restrictCreation && !ls.HasPermission(key, ls.currUserName, PermissionCreate) {
+		return ErrDenied
+	}
+	return nil
+}
+
+// Namespace n of variable n::x
+func namespaceOf(varname string) (string, bool) {
+	if i := strings.LastIndex(varname, namespaceSep); i >= 0 {
+		return varname[:i], true
+	}
+	return "", false
+}
+
+// Key n:: of namespace n delegations in assertions
+func isNamespaceKey(varname string) bool {
+	return strings.HasSuffix(varname, namespaceSep)
+}
+
+func (ls *LocalStore) namespaceKeyExists(varname string) bool {
+	return varname == rootNamespace ||
+		isNamespaceKey(varname) && ls.namespaces[strings.TrimSuffix(varname, namespaceSep)]
+}
+
 func (ls *LocalStore) isGlobalVarExist(varname string) bool {
-	if _, ok := ls.global.vars[varname]; ok { // global variable exists
+	if _, ok := ls.globalVar(varname); ok { // global variable exists
 		return true
 	}
 	if _, ok := ls.vars[varname]; ok { // pending variable exists
@@ -590,6 +2037,94 @@ func (ls *LocalStore) isGlobalVarExist(varname string) bool {
 	return false
 }
 
+// returns value of committed global variable unless it is deleted by this local store
+func (ls *LocalStore) globalVar(varname string) (interface{}, bool) {
+	if ls.deletedVars[varname] {
+		return nil, false
+	}
+	v, ok := ls.global.vars[varname]
+	return v, ok
+}
+
+// returns value of variable (local, pending or global) without any permission checks
+func (ls *LocalStore) lookup(varname string) (interface{}, bool) {
+	if v, ok := ls.locals[varname]; ok { // local variable exists
+		return v, true
+	}
+	if v, ok := ls.vars[varname]; ok { // pending variable exists
+		return v, true
+	}
+	if v, ok := ls.globalVar(varname); ok { // global variable exists
+		return v, true
+	}
+	return nil, false
+}
+
+// updates existing variable (local or global) without any permission checks
+func (ls *LocalStore) put(varname string, val interface{}) error {
+	if ls.isLocal(varname) {
+		return ls.putLocal(varname, val)
+	}
+	return ls.putGlobal(varname, val)
+}
+
+// sets pending global variable without any permission checks
+func (ls *LocalStore) putGlobal(varname string, val interface{}) error {
+	delta := varSize(varname, val)
+	if old, ok := ls.lookup(varname); ok {
+		delta -= varSize(varname, old)
+	}
+	return ls.write(varname, val, false, delta)
+}
+
+// sets local variable without any permission checks
+func (ls *LocalStore) putLocal(varname string, val interface{}) error {
+	delta := varSize(varname, val)
+	if old, ok := ls.locals[varname]; ok {
+		delta -= varSize(varname, old)
+	}
+	return ls.write(varname, val, true, delta)
+}
+
+// stores the value of local or pending global variable, delta is the change of the store size.
+// Fails if the store with local variables would exceed the size limit.
+func (ls *LocalStore) write(varname string, val interface{}, local bool, delta int64) error {
+	limit := ls.global.maxSize
+	if limit > 0 && delta > 0 && ls.global.size+ls.sizeDelta+ls.localsSize+delta > limit {
+		log.Printf("store: size limit %d exceeded by %s on %s", limit, ls.currUserName, varname)
+		return ErrFailed
+	}
+	if local {
+		ls.localsSize += delta
+		ls.locals[varname] = val
+	} else {
+		ls.sizeDelta += delta
+		ls.vars[varname] = val
+	}
+	return nil
+}
+
+// removes variable (local or global) without any permission checks
+func (ls *LocalStore) remove(varname string) {
+	if v, ok := ls.locals[varname]; ok {
+		ls.localsSize -= varSize(varname, v)
+		delete(ls.locals, varname)
+		return
+	}
+	if v, ok := ls.lookup(varname); ok {
+		ls.sizeDelta -= varSize(varname, v)
+	}
+	delete(ls.vars, varname)
+	if _, ok := ls.global.vars[varname]; ok {
+		ls.deletedVars[varname] = true
+	}
+}
+
+func (ls *LocalStore) isLocal(varname string) bool {
+	_, ok := ls.locals[varname]
+	return ok
+}
+
 func (ls *LocalStore) IsVarExist(varname string) bool {
 	if ls.isGlobalVarExist(varname) {
 		return true
@@ -603,8 +2138,8 @@ func (ls *LocalStore) IsVarExist(varname string) bool {
 
 func (lv ListVal) Flatten() ListVal {
 	out := make(ListVal, len(lv))
-	out, _ = flatten(out, lv, 0)
-	return out
+	out, n := flatten(out, lv, 0)
+	return out[:n]
 }
 
 func flatten(out, in ListVal, pos int) (ListVal, int) {
@@ -634,6 +2169,64 @@ func flatten(out, in ListVal, pos int) (ListVal, int) {
 	return out, n
 }
 
+// length of the flattened list
+func listLength(lst ListVal) int64 {
+	n := int64(0)
+	for _, val := range lst {
+		if l, ok := val.(ListVal); ok {
+			n += listLength(l)
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// approximate size of the value in bytes: length of strings and record fields
+func valueSize(val interface{}) int64 {
+	switch v := val.(type) {
+	case string:
+		return int64(len(v))
+	case NumberVal:
+		return 8
+	case ListVal:
+		n := int64(0)
+		for _, e := range v {
+			n += valueSize(e)
+		}
+		return n
+	case RecordVal:
+		n := int64(0)
+		for k, f := range v {
+			n += int64(len(k) + len(f))
+		}
+		return n
+	}
+	return 0
+}
+
+// size of the variable accounted in the store size limit
+func varSize(varname string, val interface{}) int64 {
+	return int64(len(varname)) + valueSize(val)
+}
+
+// copy of list and record values, so changes of the copy do not affect the original
+func copyValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case ListVal:
+		res := make(ListVal, len(v))
+		copy(res, v)
+		return res
+	case RecordVal:
+		res := make(RecordVal, len(v))
+		for k, f := range v {
+			res[k] = f
+		}
+		return res
+	}
+	return val
+}
+
 func randPass() string {
 	letterRunes := []rune("1234567890abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_!,.?")
 	rand.Seed(time.Now().UnixNano())
@@ -1,6 +1,15 @@
 package store
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
 
 func TestAsPrincipal(t *testing.T) {
 	s := NewStore("password")
@@ -611,3 +620,1188 @@ func TestCiclePermission(t *testing.T) {
 		t.Errorf("ca should not have PermissionRead")
 	}
 }
+
+func TestFlatten(t *testing.T) {
+	lst := ListVal{ListVal{}, "a", ListVal{"b", ListVal{"c"}}, ListVal{}}
+	res := lst.Flatten()
+	if len(res) != 3 || res[0] != "a" || res[1] != "b" || res[2] != "c" {
+		t.Errorf("Invalid flattened list: %v", res)
+	}
+}
+
+func TestSetField(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("rec", RecordVal{"a": "1"})
+	ls.Set("str", "str")
+	ls.SetDelegation("rec", "admin", PermissionAppend, "alice")
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.SetField("rec", "a", "2"); err != ErrDenied {
+		t.Errorf("Set field without write permission should be denied: %v", err)
+	}
+	if err = ls.AppendToField("rec", "a", "2"); err != ErrDenied {
+		t.Errorf("Append to field without write permission should be denied: %v", err)
+	}
+	if err = ls.SetField("missing", "a", "2"); err != ErrFailed {
+		t.Errorf("Set field of missing variable should fail: %v", err)
+	}
+	ls.SetLocal("local", RecordVal{"a": "1"})
+	if err = ls.SetField("local", "b", "2"); err != nil {
+		t.Errorf("Set field of local variable should not fail: %v", err)
+	}
+	if v, _ := ls.Get("local"); len(v.(RecordVal)) != 2 || v.(RecordVal)["b"] != "2" {
+		t.Errorf("Invalid local record after set field: %v", v)
+	}
+	ls.Commit()
+
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.AppendToField("rec", "a", "2"); err != nil {
+		t.Errorf("Append to field should not fail: %v", err)
+	}
+	if v, _ := ls.Get("rec"); v.(RecordVal)["a"] != "12" {
+		t.Errorf("Invalid record after append to field: %v", v)
+	}
+	if err = ls.SetField("rec", "b", "3"); err != nil {
+		t.Errorf("Set field should not fail: %v", err)
+	}
+	if v, _ := ls.Get("rec"); len(v.(RecordVal)) != 2 || v.(RecordVal)["b"] != "3" {
+		t.Errorf("Invalid record after set field: %v", v)
+	}
+	if err = ls.SetField("str", "a", "2"); err != ErrFailed {
+		t.Errorf("Set field of non-record should fail: %v", err)
+	}
+}
+
+func TestSetIndex(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", ListVal{"a", ListVal{"b", "c"}})
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	if err = ls.SetIndex("x", -2, "B"); err != nil {
+		t.Errorf("Set index should not fail: %v", err)
+	}
+	if v, _ := ls.Get("x"); len(v.(ListVal)) != 3 || v.(ListVal)[1] != "B" {
+		t.Errorf("Invalid list after set index: %v", v)
+	}
+	if err = ls.SetIndex("x", 3, "d"); err != ErrFailed {
+		t.Errorf("Set index out of range should fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.SetIndex("x", 0, "A"); err != ErrDenied {
+		t.Errorf("Set index without write permission should be denied: %v", err)
+	}
+}
+
+func TestRemoveFromList(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", ListVal{"1", ListVal{"2", "3"}})
+	ls.SetDelegation("x", "admin", PermissionAppend, "alice")
+	ls.SetDelegation("x", "admin", PermissionRemove, "bob")
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.PrependTo("x", "0"); err != nil {
+		t.Errorf("Prepend with append permission should not fail: %v", err)
+	}
+	if err = ls.Pop("x"); err != ErrDenied {
+		t.Errorf("Pop with append permission should be denied: %v", err)
+	}
+	removeAll := func(val interface{}) (bool, error) { return true, nil }
+	if err = ls.RemoveFrom("x", removeAll); err != ErrDenied {
+		t.Errorf("Remove with append permission should be denied: %v", err)
+	}
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("bob", "bob")
+	if err != nil {
+		t.Fatalf("bob login fail")
+	}
+	if err = ls.PrependTo("x", "0"); err != ErrDenied {
+		t.Errorf("Prepend with remove permission should be denied: %v", err)
+	}
+	if err = ls.Pop("x"); err != nil {
+		t.Errorf("Pop with remove permission should not fail: %v", err)
+	}
+	removeOne := func(val interface{}) (bool, error) { return val == "1", nil }
+	if err = ls.RemoveFrom("x", removeOne); err != nil {
+		t.Errorf("Remove with remove permission should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if v, _ := ls.Get("x"); len(v.(ListVal)) != 2 || v.(ListVal)[0] != "0" || v.(ListVal)[1] != "2" {
+		t.Errorf("Invalid list after prepend, pop and remove: %v", v)
+	}
+	ls.Set("empty", ListVal{})
+	if err = ls.Pop("empty"); err != ErrFailed {
+		t.Errorf("Pop from empty list should fail: %v", err)
+	}
+}
+
+func TestDeleteVar(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.SetDelegation("x", "admin", PermissionRead, "bob")
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.DeleteVar("x"); err != ErrDenied {
+		t.Errorf("Delete without write permission should be denied: %v", err)
+	}
+	if err = ls.DeleteVar("missing"); err != ErrFailed {
+		t.Errorf("Delete of missing variable should fail: %v", err)
+	}
+
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.DeleteVar("x"); err != nil {
+		t.Errorf("Admin should be able to delete variable: %v", err)
+	}
+	if _, err = ls.Get("x"); err != ErrFailed {
+		t.Errorf("Deleted variable should not exist: %v", err)
+	}
+	// NO COMMIT HERE
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if _, err = ls.Get("x"); err != nil {
+		t.Errorf("Variable should exist if delete is not committed: %v", err)
+	}
+	ls.DeleteVar("x")
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.Set("x", "new"); err != nil {
+		t.Errorf("Should be able to create deleted variable: %v", err)
+	}
+	ls.Commit()
+	ls, err = s.AsPrincipal("bob", "bob")
+	if err != nil {
+		t.Fatalf("bob login fail")
+	}
+	if _, err = ls.Get("x"); err != ErrDenied {
+		t.Errorf("Delegations of deleted variable should be deleted: %v", err)
+	}
+	if !ls.HasPermission("x", "alice", PermissionDelegate) {
+		t.Errorf("Creator should have default delegations on recreated variable")
+	}
+}
+
+func TestDeletePrincipal(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.SetDelegation("x", "alice", PermissionRead, "bob")
+	ls.SetDefaultDelegator("alice")
+	ls.Commit()
+
+	ls, err = s.AsPrincipal("alice", "alice")
+	if err != nil {
+		t.Fatalf("alice login fail")
+	}
+	if err = ls.DeletePrincipal("bob"); err != ErrDenied {
+		t.Errorf("Delete principal by non admin should be denied: %v", err)
+	}
+
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.DeletePrincipal(adminUsername); err != ErrFailed {
+		t.Errorf("Delete admin should fail: %v", err)
+	}
+	if err = ls.DeletePrincipal(anyoneUsername); err != ErrFailed {
+		t.Errorf("Delete anyone should fail: %v", err)
+	}
+	if err = ls.DeletePrincipal("alice"); err != nil {
+		t.Errorf("Delete principal should not fail: %v", err)
+	}
+	if ls.getDefaultDelegator() != anyoneUsername {
+		t.Errorf("Default delegator should be reset to anyone")
+	}
+	if err = ls.DeletePrincipal("alice"); err != ErrFailed {
+		t.Errorf("Delete of deleted principal should fail: %v", err)
+	}
+	ls.Commit()
+
+	if _, err = s.AsPrincipal("alice", "alice"); err != ErrFailed {
+		t.Errorf("Deleted principal should not exist: %v", err)
+	}
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.CreatePrincipal("alice", "alice"); err != nil {
+		t.Errorf("Should be able to create deleted principal: %v", err)
+	}
+	if ls.HasPermission("x", "alice", PermissionRead) || ls.HasPermission("x", "bob", PermissionRead) {
+		t.Errorf("Delegations of deleted principal should be deleted")
+	}
+}
+
+func TestDisablePrincipal(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	if err = ls.DisablePrincipal("alice"); err != nil {
+		t.Errorf("Disable principal should not fail: %v", err)
+	}
+	if err = ls.DisablePrincipal(adminUsername); err != ErrFailed {
+		t.Errorf("Disable admin should fail: %v", err)
+	}
+	ls.Commit()
+	if _, err = s.AsPrincipal("alice", "alice"); err != ErrDenied {
+		t.Errorf("Disabled principal should be denied: %v", err)
+	}
+
+	ls, err = s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.EnablePrincipal("alice"); err != nil {
+		t.Errorf("Enable principal should not fail: %v", err)
+	}
+	ls.Commit()
+	if _, err = s.AsPrincipal("alice", "alice"); err != nil {
+		t.Errorf("Enabled principal should be able to login: %v", err)
+	}
+}
+
+func TestRenameVar(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	ls.Set("x", "x")
+	ls.Set("z", "z")
+	ls.SetDelegation("x", "alice", PermissionRead, "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.RenameVar("x", "y"); err != ErrDenied {
+		t.Errorf("Rename without write permission should be denied: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.RenameVar("x", "z"); err != ErrFailed {
+		t.Errorf("Rename to existing variable should fail: %v", err)
+	}
+	if err = ls.RenameVar("w", "y"); err != ErrFailed {
+		t.Errorf("Rename of missing variable should fail: %v", err)
+	}
+	if err = ls.RenameVar("x", "y"); err != nil {
+		t.Errorf("Rename should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if ls.IsVarExist("x") {
+		t.Errorf("Renamed variable should not exist")
+	}
+	if v, err := ls.Get("y"); err != nil || v != "x" {
+		t.Errorf("Renamed variable should keep value and delegations: %v %v", v, err)
+	}
+}
+
+func TestCopyVar(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", ListVal{"a"})
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.SetDelegation("x", "admin", PermissionRead, "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CopyVar("x", "y", false); err != ErrDenied {
+		t.Errorf("Copy without write and delegate permission should be denied: %v", err)
+	}
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.SetDelegation("x", "admin", PermissionWrite|PermissionDelegate, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CopyVar("x", "y", false); err != nil {
+		t.Errorf("Copy should not fail: %v", err)
+	}
+	if err = ls.CopyVar("x", "y", false); err != ErrFailed {
+		t.Errorf("Copy to existing variable should fail: %v", err)
+	}
+	if err = ls.AppendTo("y", "b"); err != nil {
+		t.Errorf("Append to copy should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if v, _ := ls.Get("x"); len(v.(ListVal)) != 1 {
+		t.Errorf("Change of copy should not change original: %v", v)
+	}
+	if !ls.HasPermission("y", "alice", PermissionWrite) || ls.HasPermission("y", "bob", PermissionRead) {
+		t.Errorf("Copy without delegations should have new variable permissions")
+	}
+	if err = ls.CopyVar("x", "z", true); err != nil {
+		t.Errorf("Copy with delegations should not fail: %v", err)
+	}
+	if !ls.HasPermission("z", "bob", PermissionRead) {
+		t.Errorf("Copy with delegations should copy delegations")
+	}
+}
+
+func TestExplainPermission(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.CreatePrincipal("carol", "carol")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.SetDelegation("x", "admin", PermissionDelegate, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	ls.SetDelegation("x", "alice", PermissionRead, "bob")
+	ls.SetDelegation("x", "alice", PermissionRead, "anyone")
+	if _, err = ls.ExplainPermission("x", "bob", PermissionRead); err != ErrDenied {
+		t.Errorf("Explain by non admin should be denied: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if _, err = ls.ExplainPermission("y", "bob", PermissionRead); err != ErrFailed {
+		t.Errorf("Explain of missing variable should fail: %v", err)
+	}
+	res, err := ls.ExplainPermission("x", "bob", PermissionRead)
+	expected := ListVal{"admin -> alice -> bob", "admin -> alice -> anyone -> bob"}
+	if err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("Explain paths expected %v, got %v %v", expected, res, err)
+	}
+	res, _ = ls.ExplainPermission("x", "carol", PermissionWrite)
+	if res != "no delegation of write on x to carol or anyone" {
+		t.Errorf("Unexpected explain reason: %v", res)
+	}
+
+	ls.SetDelegation("x", "bob", PermissionWrite, "carol")
+	res, _ = ls.ExplainPermission("x", "carol", PermissionWrite)
+	if res != "no path to admin from delegators bob of write on x to carol" {
+		t.Errorf("Unexpected explain reason: %v", res)
+	}
+}
+
+func TestExplainPermissionBounded(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.Set("x", "x")
+	ls.CreatePrincipal("p", "p")
+	ls.SetDelegation("x", "admin", PermissionRead, "p")
+	ls.SetDelegation("x", "admin", PermissionDelegate, "p")
+	// ladder of layers with two principals each, every principal delegating to both of the next layer,
+	// the last layer delegates back to p, so there are 2^layers cyclic paths to p
+	const layers = 40
+	prev := []string{"p"}
+	for i := 0; i < layers; i++ {
+		cur := []string{fmt.Sprintf("q%da", i), fmt.Sprintf("q%db", i)}
+		for _, q := range cur {
+			ls.CreatePrincipal(q, q)
+			for _, owner := range prev {
+				ls.SetDelegation("x", owner, PermissionRead, q)
+				ls.SetDelegation("x", owner, PermissionDelegate, q)
+			}
+		}
+		prev = cur
+	}
+	for _, owner := range prev {
+		ls.SetDelegation("x", owner, PermissionRead, "p")
+	}
+	res, err := ls.ExplainPermission("x", "p", PermissionRead)
+	if expected := (ListVal{"admin -> p"}); err != nil || !reflect.DeepEqual(res, expected) {
+		t.Errorf("Explain paths expected %v, got %v %v", expected, res, err)
+	}
+	res, _ = ls.ExplainPermission("x", prev[0], PermissionRead)
+	if paths, ok := res.(ListVal); !ok || len(paths) != maxExplainPaths {
+		t.Errorf("Explain should stop after %v paths, got %v", maxExplainPaths, res)
+	}
+}
+
+func TestPermissionsAndRights(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.SetDelegation("x", "admin", PermissionAppend, "anyone")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if _, err = ls.Permissions("x"); err != ErrDenied {
+		t.Errorf("Permissions without delegate permission should be denied: %v", err)
+	}
+	rights, err := ls.Rights("x")
+	if expected := (ListVal{"read", "append"}); err != nil || !reflect.DeepEqual(rights, expected) {
+		t.Errorf("Rights expected %v, got %v %v", expected, rights, err)
+	}
+	if _, err = ls.Rights("y"); err != ErrFailed {
+		t.Errorf("Rights of missing variable should fail: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	perms, err := ls.Permissions("x")
+	expected := ListVal{
+		RecordVal{"right": "read", "user": "admin"},
+		RecordVal{"right": "read", "user": "alice"},
+		RecordVal{"right": "write", "user": "admin"},
+		RecordVal{"right": "append", "user": "admin"},
+		RecordVal{"right": "append", "user": "alice"},
+		RecordVal{"right": "append", "user": "anyone"},
+		RecordVal{"right": "append", "user": "bob"},
+		RecordVal{"right": "delegate", "user": "admin"},
+		RecordVal{"right": "remove", "user": "admin"},
+	}
+	if err != nil || !reflect.DeepEqual(perms, expected) {
+		t.Errorf("Permissions expected %v, got %v %v", expected, perms, err)
+	}
+}
+
+func TestDelegations(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionWrite, "alice")
+	ls.SetDelegation("x", "admin", PermissionRead, "alice")
+	ls.CreateNamespace("team")
+	ls.SetDelegation("team::", "admin", PermissionCreate, "alice")
+	ls.Commit()
+
+	edges, err := ls.Delegations()
+	expected := []DelegationEdge{
+		{Var: "team::", Owner: "admin", Target: "alice", Right: "create", Namespace: true},
+		{Var: "x", Owner: "admin", Target: "alice", Right: "read"},
+		{Var: "x", Owner: "admin", Target: "alice", Right: "write"},
+	}
+	if err != nil || !reflect.DeepEqual(edges, expected) {
+		t.Errorf("Delegations expected %v, got %v %v", expected, edges, err)
+	}
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if _, err = ls.Delegations(); err != ErrDenied {
+		t.Errorf("Delegations by non admin should be denied: %v", err)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	if err = ls.CreateGroup("alice"); err != ErrFailed {
+		t.Errorf("Create group with principal name should fail: %v", err)
+	}
+	if err = ls.CreateGroup("devs"); err != nil {
+		t.Errorf("Create group should not fail: %v", err)
+	}
+	if err = ls.CreatePrincipal("devs", "devs"); err != ErrFailed {
+		t.Errorf("Create principal with group name should fail: %v", err)
+	}
+	ls.CreateGroup("team")
+	if err = ls.AddToGroup("alice", "devs"); err != nil {
+		t.Errorf("Add to group should not fail: %v", err)
+	}
+	if err = ls.AddToGroup("devs", "team"); err != nil {
+		t.Errorf("Add group to group should not fail: %v", err)
+	}
+	if err = ls.AddToGroup("team", "devs"); err != ErrFailed {
+		t.Errorf("Add making group cycle should fail: %v", err)
+	}
+	if err = ls.AddToGroup("team", "team"); err != ErrFailed {
+		t.Errorf("Add group to itself should fail: %v", err)
+	}
+	if err = ls.SetDelegation("x", "admin", PermissionRead, "team"); err != nil {
+		t.Errorf("Set delegation to group should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CreateGroup("ops"); err != ErrDenied {
+		t.Errorf("Create group by non admin should be denied: %v", err)
+	}
+	if !ls.HasPermission("x", "alice", PermissionRead) {
+		t.Errorf("Member of nested group should have permission")
+	}
+	if ls.HasPermission("x", "bob", PermissionRead) {
+		t.Errorf("Non member should not have permission")
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.RemoveFromGroup("bob", "devs"); err != ErrFailed {
+		t.Errorf("Remove of non member should fail: %v", err)
+	}
+	if err = ls.RemoveFromGroup("devs", "team"); err != nil {
+		t.Errorf("Remove from group should not fail: %v", err)
+	}
+	if ls.HasPermission("x", "alice", PermissionRead) {
+		t.Errorf("Removed member should not have permission")
+	}
+}
+
+func TestExpiringDelegation(t *testing.T) {
+	now := time.Date(2016, 9, 1, 12, 0, 0, 0, time.UTC)
+	s := NewStore("password")
+	s.SetClock(func() time.Time { return now })
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", "x")
+	if err = ls.SetDelegationExpiring("x", "admin", PermissionRead, "alice", now); err != ErrFailed {
+		t.Errorf("Set delegation expiring in the past should fail: %v", err)
+	}
+	if err = ls.SetDelegationExpiring("x", "admin", PermissionRead, "alice", now.Add(time.Hour)); err != nil {
+		t.Errorf("Set expiring delegation should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if !ls.HasPermission("x", "alice", PermissionRead) {
+		t.Errorf("Delegation should be valid before expiration")
+	}
+
+	now = now.Add(time.Hour)
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if ls.HasPermission("x", "alice", PermissionRead) {
+		t.Errorf("Expired delegation should be ignored")
+	}
+	ls.Commit()
+	if _, ok := s.assertions["x"]["alice"][PermissionRead]["admin"]; ok {
+		t.Errorf("Expired delegation should be deleted on commit")
+	}
+}
+
+func TestDenial(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead, "anyone")
+	ls.SetDelegation("x", "admin", PermissionDelegate, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.SetDenial("x", "bob", PermissionRead, "alice"); err != ErrDenied {
+		t.Errorf("Set denial without delegate permission should be denied: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.SetDenial("x", "alice", PermissionRead, "admin"); err != ErrFailed {
+		t.Errorf("Set denial to admin should fail: %v", err)
+	}
+	if !ls.HasPermission("x", "bob", PermissionRead) {
+		t.Errorf("Bob should have permission before denial")
+	}
+	if err = ls.SetDenial("x", "alice", PermissionRead, "bob"); err != nil {
+		t.Errorf("Set denial should not fail: %v", err)
+	}
+	if ls.HasPermission("x", "bob", PermissionRead) {
+		t.Errorf("Denial should override delegation to anyone")
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if _, err = ls.Get("x"); err != ErrDenied {
+		t.Errorf("Get of denied variable should be denied: %v", err)
+	}
+	if err = ls.DeleteDenial("x", "alice", PermissionRead, "bob"); err != ErrDenied {
+		t.Errorf("Delete denial by denied principal should be denied: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.DeleteDenial("x", "alice", PermissionRead, "bob"); err != nil {
+		t.Errorf("Delete denial should not fail: %v", err)
+	}
+	if !ls.HasPermission("x", "bob", PermissionRead) {
+		t.Errorf("Bob should have permission after denial is deleted")
+	}
+}
+
+func TestDenialOfRevokedDelegator(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionWrite|PermissionDelegate, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	ls.SetDelegation("x", "alice", PermissionDelegate, "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.SetDenial("x", "bob", PermissionWrite, "alice"); err != nil {
+		t.Errorf("Set denial should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.Set("x", "y"); err != ErrDenied {
+		t.Errorf("Set of denied variable should be denied: %v", err)
+	}
+	if err = ls.DeleteDelegation("x", "alice", PermissionDelegate, "bob"); err != nil {
+		t.Errorf("Delete delegation should not fail: %v", err)
+	}
+	if err = ls.Set("x", "y"); err != nil {
+		t.Errorf("Denial should not apply after its owner lost delegate permission: %v", err)
+	}
+	ls.Commit()
+
+	// denials of delegators denying each other must not loop
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.SetDelegation("x", "admin", PermissionDelegate, "bob")
+	ls.SetDenial("x", "alice", PermissionDelegate, "bob")
+	ls.SetDenial("x", "bob", PermissionDelegate, "alice")
+	if ls.HasPermission("x", "alice", PermissionDelegate) == ls.HasPermission("x", "bob", PermissionDelegate) {
+		t.Errorf("Exactly one of the delegators denying each other should keep delegate permission")
+	}
+}
+
+func TestDelegationRightList(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.Set("x", "x")
+	if err = ls.SetDelegation("x", "admin", PermissionRead|PermissionWrite, "alice"); err != nil {
+		t.Errorf("Set delegation of right list should not fail: %v", err)
+	}
+	if !ls.HasPermission("x", "alice", PermissionRead) || !ls.HasPermission("x", "alice", PermissionWrite) ||
+		ls.HasPermission("x", "alice", PermissionAppend) {
+		t.Errorf("Only listed rights should be delegated")
+	}
+	if err = ls.DeleteDelegation("x", "admin", PermissionAll, "alice"); err != nil {
+		t.Errorf("Delete delegation of all rights should not fail: %v", err)
+	}
+	for _, perm := range allPermissions {
+		if ls.HasPermission("x", "alice", perm) {
+			t.Errorf("%v should be deleted", perm)
+		}
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"logs_*", "logs_1", true},
+		{"logs_*", "logs_", true},
+		{"logs_*", "xlogs_1", false},
+		{"*_log", "app_log", true},
+		{"*_log", "app_log2", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{"*", "", true},
+		{"x", "x", true},
+		{"team::logs_*", "team::logs_1", true},
+		{"team::logs_*", "logs_1", false},
+		{"logs_*", "team::logs_1", false},
+		{"*", "team::x", false},
+		{"t*::*", "team::x", true},
+		{"t*", "t::x", false},
+	}
+	for _, tt := range tests {
+		if res := matchPattern(tt.pattern, tt.name); res != tt.match {
+			t.Errorf("matchPattern(%q, %q) expected %v, got %v", tt.pattern, tt.name, tt.match, res)
+		}
+	}
+}
+
+func TestPatternDelegation(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	if err = ls.SetPatternDelegation("logs.*", "admin", PermissionRead, "alice", time.Time{}); err != ErrFailed {
+		t.Errorf("Invalid pattern should fail: %v", err)
+	}
+	if err = ls.SetPatternDelegation("logs_*", "admin", PermissionRead, "alice", time.Time{}); err != nil {
+		t.Errorf("Set pattern delegation should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.Set("logs_1", "l")
+	ls.Set("data", "d")
+	if !ls.HasPermission("logs_1", "alice", PermissionRead) {
+		t.Errorf("Pattern delegation should apply to variable created later")
+	}
+	if ls.HasPermission("data", "alice", PermissionRead) {
+		t.Errorf("Pattern delegation should not apply to not matching variable")
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.SetPatternDelegation("logs_*", "alice", PermissionRead, "bob", time.Time{}); err != nil {
+		t.Errorf("Set pattern delegation should not fail: %v", err)
+	}
+	if ls.HasPermission("logs_1", "bob", PermissionRead) {
+		t.Errorf("Pattern delegation should not apply without owner delegate permission")
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.SetDelegation("logs_1", "admin", PermissionDelegate, "alice")
+	if !ls.HasPermission("logs_1", "bob", PermissionRead) {
+		t.Errorf("Pattern delegation should apply with owner delegate permission")
+	}
+	if err = ls.DeletePatternDelegation("logs_*", "admin", PermissionRead, "alice"); err != nil {
+		t.Errorf("Delete pattern delegation should not fail: %v", err)
+	}
+	if ls.HasPermission("logs_1", "alice", PermissionRead) {
+		t.Errorf("Deleted pattern delegation should not apply")
+	}
+}
+
+func TestNamespacedPatternDelegation(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreateNamespace("team")
+	for _, pattern := range []string{"team::", "::logs", "a::b::c", "team:logs"} {
+		if err = ls.SetPatternDelegation(pattern, "admin", PermissionRead, "alice", time.Time{}); err != ErrFailed {
+			t.Errorf("Invalid pattern %q should fail: %v", pattern, err)
+		}
+	}
+	if err = ls.SetPatternDelegation("team::logs_*", "admin", PermissionRead, "alice", time.Time{}); err != nil {
+		t.Errorf("Set namespaced pattern delegation should not fail: %v", err)
+	}
+	ls.Set("team::logs_1", "l")
+	ls.Set("logs_1", "l")
+	if !ls.HasPermission("team::logs_1", "alice", PermissionRead) {
+		t.Errorf("Namespaced pattern delegation should apply to matching variable")
+	}
+	if ls.HasPermission("logs_1", "alice", PermissionRead) {
+		t.Errorf("Namespaced pattern delegation should not apply to variable out of namespace")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.Set("team::x", "x"); err != ErrFailed {
+		t.Errorf("Set in missing namespace should fail: %v", err)
+	}
+	if err = ls.CreateNamespace("team"); err != nil {
+		t.Errorf("Create namespace should not fail: %v", err)
+	}
+	if err = ls.CreateNamespace("team"); err != ErrFailed {
+		t.Errorf("Create existing namespace should fail: %v", err)
+	}
+	if err = ls.SetDelegation("team::", "alice", PermissionRead, "bob"); err != nil {
+		t.Errorf("Set delegation on namespace should not fail: %v", err)
+	}
+	if err = ls.Set("team::x", "x"); err != nil {
+		t.Errorf("Set in namespace should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.SetDelegation("team::", "bob", PermissionRead, "anyone"); err != ErrDenied {
+		t.Errorf("Set delegation on namespace without delegate permission should be denied: %v", err)
+	}
+	if v, err := ls.Get("team::x"); err != nil || v != "x" {
+		t.Errorf("Variable in namespace should inherit namespace delegations: %v %v", v, err)
+	}
+	if !ls.HasPermission("team::x", "alice", PermissionWrite) {
+		t.Errorf("Creator should have rights on variable in namespace")
+	}
+}
+
+func TestRestrictedCreation(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.CreateNamespace("team")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.SetRestrictedCreation(true); err != ErrDenied {
+		t.Errorf("Set creation policy by non admin should be denied: %v", err)
+	}
+	if err = ls.Set("x", "x"); err != nil {
+		t.Errorf("Creation should be open by default: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.SetRestrictedCreation(true); err != nil {
+		t.Errorf("Set creation policy should not fail: %v", err)
+	}
+	if err = ls.SetDelegation("x", "admin", PermissionCreate, "alice"); err != ErrFailed {
+		t.Errorf("Create right on variable should fail: %v", err)
+	}
+	if err = ls.SetDelegation("team::", "admin", PermissionCreate, "bob"); err != nil {
+		t.Errorf("Create right on namespace should not fail: %v", err)
+	}
+	if err = ls.SetDelegation("all", "admin", PermissionCreate, "alice"); err != nil {
+		t.Errorf("Create right on all namespaces should not fail: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.Set("y", "y"); err != ErrDenied {
+		t.Errorf("Restricted creation without create right should be denied: %v", err)
+	}
+	if err = ls.Set("team::y", "y"); err != nil {
+		t.Errorf("Creation in namespace with create right should not fail: %v", err)
+	}
+	if err = ls.CreateNamespace("ops"); err != ErrDenied {
+		t.Errorf("Restricted namespace creation should be denied: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.Set("z", "z"); err != nil {
+		t.Errorf("Creation with create right should not fail: %v", err)
+	}
+	if err = ls.Set("team::z", "z"); err != nil {
+		t.Errorf("Creation in namespace with create right should not fail: %v", err)
+	}
+	if err = ls.Set("x", "x2"); err != nil {
+		t.Errorf("Write of existing variable should not need create right: %v", err)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.GrantCapability(CapabilityPrincipals, "alice"); err != ErrDenied {
+		t.Errorf("Grant capability by non admin should be denied: %v", err)
+	}
+	if err = ls.CreatePrincipal("carol", "carol"); err != ErrDenied {
+		t.Errorf("Create principal without capability should be denied: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.GrantCapability(CapabilityPrincipals, "anyone"); err != ErrFailed {
+		t.Errorf("Grant capability to anyone should fail: %v", err)
+	}
+	ls.GrantCapability(CapabilityPrincipals|CapabilityPasswords|CapabilityDelegator, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CreatePrincipal("carol", "carol"); err != nil {
+		t.Errorf("Create principal with capability should not fail: %v", err)
+	}
+	if err = ls.ChangePassword("bob", "new"); err != nil {
+		t.Errorf("Change password with capability should not fail: %v", err)
+	}
+	if err = ls.ChangePassword(adminUsername, "new"); err != ErrDenied {
+		t.Errorf("Change admin password with capability should be denied: %v", err)
+	}
+	if err = ls.SetDefaultDelegator("bob"); err != nil {
+		t.Errorf("Set default delegator with capability should not fail: %v", err)
+	}
+	if err = ls.CreateGroup("team"); err != ErrDenied {
+		t.Errorf("Create group without capability should be denied: %v", err)
+	}
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.RevokeCapability(CapabilityPrincipals, "alice")
+	ls.Commit()
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CreatePrincipal("dave", "dave"); err != ErrDenied {
+		t.Errorf("Create principal with revoked capability should be denied: %v", err)
+	}
+	if err = ls.ChangePassword("bob", "bob"); err != nil {
+		t.Errorf("Not revoked capability should be kept: %v", err)
+	}
+}
+
+func TestCapabilityAuditOnCommit(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.GrantCapability(CapabilityPrincipals, "alice")
+	if buf.Len() != 0 {
+		t.Errorf("Audit records should not be logged before commit: %q", buf.String())
+	}
+	ls.Commit()
+	if !strings.Contains(buf.String(), "audit: admin granted capability") {
+		t.Errorf("Audit record should be logged on commit: %q", buf.String())
+	}
+
+	buf.Reset()
+	ls, _ = s.AsPrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	if buf.Len() != 0 {
+		t.Errorf("Capability use should not be logged before commit: %q", buf.String())
+	}
+	ls, _ = s.AsPrincipal("alice", "alice")
+	ls.Commit()
+	if buf.Len() != 0 {
+		t.Errorf("Capability use of not committed program should not be logged: %q", buf.String())
+	}
+}
+
+func TestCapabilityCreatePrincipalDefaultDelegator(t *testing.T) {
+	s := NewStore("password")
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.Set("x", "x")
+	ls.SetDelegation("x", "admin", PermissionRead|PermissionDelegate, "bob")
+	ls.SetDefaultDelegator("bob")
+	ls.GrantCapability(CapabilityPrincipals, "alice")
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.CreatePrincipal("carol", "carol"); err != nil {
+		t.Errorf("Create principal with capability should not fail: %v", err)
+	}
+	if !ls.HasPermission("x", "carol", PermissionRead) || ls.HasPermission("x", "carol", PermissionWrite) {
+		t.Errorf("Principal created by capability holder should get rights of the default delegator")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	s := NewStore("password")
+	s.SetDefaultQuota(QuotaVariables, 2)
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	ls.CreatePrincipal("alice", "alice")
+	ls.CreatePrincipal("bob", "bob")
+	ls.SetQuota("alice", QuotaBytes, 10)
+	ls.SetQuota("alice", QuotaLength, 3)
+	ls.Commit()
+
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.SetQuota("bob", QuotaBytes, 0); err != ErrDenied {
+		t.Errorf("Set quota by non admin should be denied: %v", err)
+	}
+	ls.Set("x", "1")
+	ls.Set("y", "2")
+	if err = ls.Set("z", "3"); err != ErrQuota {
+		t.Errorf("Create variable over quota should fail: %v", err)
+	}
+	if err = ls.SetLocal("z", "3"); err != nil {
+		t.Errorf("Local variable should not be limited: %v", err)
+	}
+	ls.DeleteVar("y")
+	if err = ls.Set("w", "3"); err != nil {
+		t.Errorf("Deleted variable should not count to quota: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal("alice", "alice")
+	if err = ls.Set("a", "0123456789a"); err != ErrQuota {
+		t.Errorf("Value over bytes quota should fail: %v", err)
+	}
+	ls.Set("a", "01234")
+	ls.Set("b", ListVal{"1", "2"})
+	if err = ls.Set("a", "0123456789"); err != ErrQuota {
+		t.Errorf("Values over bytes quota should fail: %v", err)
+	}
+	if err = ls.AppendTo("b", "3"); err != nil {
+		t.Errorf("Append within quota should not fail: %v", err)
+	}
+	if err = ls.AppendTo("b", "4"); err != ErrQuota {
+		t.Errorf("Append over length quota should fail: %v", err)
+	}
+	ls.Commit()
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.SetDelegation("b", adminUsername, PermissionAppend, "bob")
+	ls.Commit()
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.AppendTo("b", "4"); err != ErrQuota {
+		t.Errorf("Quota of the creator should apply to other principals: %v", err)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.SetQuota("admin", QuotaBytes, 1); err != ErrFailed {
+		t.Errorf("Set quota of admin should fail: %v", err)
+	}
+	ls.SetQuota("bob", QuotaVariables, 0)
+	ls.Set("c", "3")
+	ls.Commit()
+	ls, _ = s.AsPrincipal("bob", "bob")
+	if err = ls.Set("z", "3"); err != nil {
+		t.Errorf("Own quota should override the default: %v", err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	s := NewStore("password")
+	s.SetMaxSize(20)
+	ls, err := s.AsPrincipal(adminUsername, "password")
+	if err != nil {
+		t.Fatalf("admin login fail")
+	}
+	if err = ls.Set("x", "123456789"); err != nil { // size 10
+		t.Errorf("Set within limit should not fail: %v", err)
+	}
+	ls.Set("y", ListVal{"1"})
+	ls.Commit()
+	if s.size != 12 {
+		t.Errorf("Store size after commit = %v, want 12", s.size)
+	}
+
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	if err = ls.Set("z", "123456789"); err != ErrFailed {
+		t.Errorf("Set over limit should fail: %v", err)
+	}
+	if err = ls.SetLocal("z", "123456789"); err != ErrFailed {
+		t.Errorf("Local variables should count to limit: %v", err)
+	}
+	if err = ls.AppendTo("y", "12345678"); err != nil {
+		t.Errorf("Append within limit should not fail: %v", err)
+	}
+	if err = ls.AppendTo("y", "1"); err != ErrFailed {
+		t.Errorf("Append over limit should fail: %v", err)
+	}
+	ls.DeleteVar("x")
+	if err = ls.Set("z", "123456789"); err != nil {
+		t.Errorf("Deleted variable should not count to limit: %v", err)
+	}
+	// not committed program does not change the store size
+	ls, _ = s.AsPrincipal(adminUsername, "password")
+	ls.Set("x", "1")
+	if s.size != 12 {
+		t.Errorf("Store size without commit = %v, want 12", s.size)
+	}
+	ls.Commit()
+	if s.size != 4 {
+		t.Errorf("Store size after update = %v, want 4", s.size)
+	}
+}